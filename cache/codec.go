@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/snappy"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes the values stored in Redis. The ID is written in the header
+// byte of every value, so values written with one codec can still be read
+// after switching to another, without flushing Redis.
+type Codec interface {
+	ID() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+// Codec IDs, stored in the low 5 bits of the header byte.
+const (
+	JSONCodecID     byte = 1
+	GobCodecID      byte = 2
+	MsgpackCodecID  byte = 3
+	ProtobufCodecID byte = 4
+)
+
+// Header byte layout. The high bit is always set, which tells framed values
+// apart from the plain JSON written before codecs were introduced, and from
+// the negative caching sentinels starting with a NUL byte.
+const (
+	headerFramed     byte = 1 << 7
+	headerCompressed byte = 1 << 6
+	headerEnvelope   byte = 1 << 5
+	headerCodecMask  byte = 1<<5 - 1
+)
+
+var codecs = map[byte]Codec{
+	JSONCodecID:     JSONCodec{},
+	GobCodecID:      GobCodec{},
+	MsgpackCodecID:  MsgpackCodec{},
+	ProtobufCodecID: ProtobufCodec{},
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ID() byte                                { return JSONCodecID }
+func (JSONCodec) Marshal(v interface{}) ([]byte, error)   { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(b []byte, v interface{}) error { return json.Unmarshal(b, v) }
+
+type GobCodec struct{}
+
+func (GobCodec) ID() byte { return GobCodecID }
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ID() byte                                { return MsgpackCodecID }
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error)   { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(b []byte, v interface{}) error { return msgpack.Unmarshal(b, v) }
+
+// ProtobufCodec encodes generated protobuf messages. The cached type must be
+// a message pointer, e.g. TypedCache[*pb.Users].
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ID() byte { return ProtobufCodecID }
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (ProtobufCodec) Unmarshal(b []byte, v interface{}) error {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(b, msg)
+	}
+	// v is a pointer to a nil message pointer, allocate the message first.
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	elem := reflect.New(rv.Elem().Type().Elem())
+	msg, ok := elem.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return err
+	}
+	rv.Elem().Set(elem)
+	return nil
+}
+
+var errShortValue = errors.New("cache: value too short")
+
+// encode frames v as a header byte, the entry when e is not nil, and the
+// encoded value, compressed with snappy when it is at least threshold bytes.
+// A threshold of zero disables compression.
+func encode(codec Codec, v interface{}, e *entry, threshold int) ([]byte, error) {
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	header := headerFramed | codec.ID()&headerCodecMask
+	if threshold > 0 && len(body) >= threshold {
+		body = snappy.Encode(nil, body)
+		header |= headerCompressed
+	}
+	if e != nil {
+		header |= headerEnvelope
+	}
+	b := make([]byte, 0, 1+16+len(body))
+	b = append(b, header)
+	if e != nil {
+		b = binary.BigEndian.AppendUint64(b, uint64(e.Expiry))
+		b = binary.BigEndian.AppendUint64(b, uint64(e.Delta))
+	}
+	return append(b, body...), nil
+}
+
+// decode reverses encode, returning the entry if one was stored. The codec
+// is picked from the header, preferring codec when the IDs match so that
+// custom codecs can be read back.
+func decode(codec Codec, b []byte, v interface{}) (*entry, error) {
+	if len(b) == 0 {
+		return nil, errShortValue
+	}
+	header := b[0]
+	b = b[1:]
+	var e *entry
+	if header&headerEnvelope != 0 {
+		if len(b) < 16 {
+			return nil, errShortValue
+		}
+		e = &entry{
+			Expiry: int64(binary.BigEndian.Uint64(b)),
+			Delta:  int64(binary.BigEndian.Uint64(b[8:])),
+		}
+		b = b[16:]
+	}
+	if header&headerCompressed != 0 {
+		var err error
+		b, err = snappy.Decode(nil, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	id := header & headerCodecMask
+	c, ok := codecs[id]
+	if codec.ID() == id {
+		c, ok = codec, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown codec %d", id)
+	}
+	return e, c.Unmarshal(b, v)
+}
+
+// decodeLegacy reads the plain JSON values written before codecs were
+// introduced, so that they do not have to be flushed.
+func decodeLegacy(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
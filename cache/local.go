@@ -0,0 +1,191 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const InvalidationChannel = "ServerName:Cache:Invalidate"
+
+// lru is an in-process cache with a fixed number of entries, each of which
+// expires after ttl.
+type lru[V any] struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruItem[V any] struct {
+	key    string
+	value  V
+	expiry time.Time
+}
+
+func newLRU[V any](size int, ttl time.Duration) *lru[V] {
+	return &lru[V]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *lru[V]) Get(key string) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var zero V
+	el, ok := l.items[key]
+	if !ok {
+		return zero, false
+	}
+	item := el.Value.(*lruItem[V])
+	if time.Now().After(item.expiry) {
+		l.ll.Remove(el)
+		delete(l.items, key)
+		return zero, false
+	}
+	l.ll.MoveToFront(el)
+	return item.value, true
+}
+
+func (l *lru[V]) Set(key string, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expiry := time.Now().Add(l.ttl)
+	if el, ok := l.items[key]; ok {
+		item := el.Value.(*lruItem[V])
+		item.value = value
+		item.expiry = expiry
+		l.ll.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.ll.PushFront(&lruItem[V]{key, value, expiry})
+	for l.ll.Len() > l.size {
+		el := l.ll.Back()
+		l.ll.Remove(el)
+		delete(l.items, el.Value.(*lruItem[V]).key)
+	}
+}
+
+func (l *lru[V]) Remove(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := l.items[key]; ok {
+			l.ll.Remove(el)
+			delete(l.items, key)
+		}
+	}
+}
+
+func (l *lru[V]) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ll.Init()
+	l.items = make(map[string]*list.Element)
+}
+
+// localTier is the part of an lru the Invalidator needs, regardless of the
+// value type.
+type localTier interface {
+	Remove(keys ...string)
+	Clear()
+}
+
+// Invalidator keeps the in-process tiers of every instance in sync. Writes
+// publish the affected keys on InvalidationChannel, and Run removes them
+// from the local tiers when they are received.
+type Invalidator struct {
+	client *redis.Client
+
+	mu     sync.RWMutex
+	locals []localTier
+}
+
+func NewInvalidator(client *redis.Client) *Invalidator {
+	return &Invalidator{client: client}
+}
+
+func (i *Invalidator) register(local localTier) {
+	i.mu.Lock()
+	i.locals = append(i.locals, local)
+	i.mu.Unlock()
+}
+
+func (i *Invalidator) remove(keys ...string) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, local := range i.locals {
+		local.Remove(keys...)
+	}
+}
+
+func (i *Invalidator) clear() {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, local := range i.locals {
+		local.Clear()
+	}
+}
+
+// Publish removes keys from the local tiers of this instance, then tells the
+// other instances to do the same.
+func (i *Invalidator) Publish(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	i.remove(keys...)
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return i.client.Publish(InvalidationChannel, b).Err()
+}
+
+// Run applies invalidations published by other instances until ctx is done.
+// Invalidations sent while the connection was down are lost, so the local
+// tiers are cleared whenever the subscription is re-established.
+func (i *Invalidator) Run(ctx context.Context) {
+	sub := i.client.Subscribe(InvalidationChannel)
+	defer sub.Close()
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	subscribed := false
+	for {
+		msg, err := sub.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The next Receive reconnects and resubscribes.
+			log.Println("invalidator: receive failed", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if subscribed {
+				i.clear()
+			}
+			subscribed = true
+		case *redis.Message:
+			var keys []string
+			if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+				log.Println("invalidator: bad payload", msg.Payload, err)
+				continue
+			}
+			i.remove(keys...)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const lockPollInterval = 50 * time.Millisecond
+
+// unlockScript deletes the lock only if it is still owned by the caller, so
+// that a lock which expired and was taken by another instance is left alone.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func lockKey(key string) string {
+	return key + ":lock"
+}
+
+func staleKey(key string) string {
+	return key + ":stale"
+}
+
+// acquire attempts to take the recompute lock for key. The returned token
+// must be passed to release.
+func acquire(client *redis.Client, key string, ttl time.Duration) (string, bool, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", false, err
+	}
+	ok, err := client.SetNX(lockKey(key), token, ttl).Result()
+	return token, ok, err
+}
+
+func release(client *redis.Client, key, token string) error {
+	return unlockScript.Run(client, []string{lockKey(key)}, token).Err()
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
 	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-const GetUsersCacheKey = "ServerName:UserGetter:GetUsers"
+const (
+	GetUsersCacheKey = "ServerName:UserGetter:GetUsers"
+	GetUserCacheKey  = "ServerName:UserGetter:GetUser"
+)
 
 type User struct {
 	Name string
@@ -16,6 +24,17 @@ type User struct {
 
 type Repository interface {
 	GetUsers() ([]User, error)
+	GetUser(name string) (User, error)
+	SaveUsers(users []User) error
+}
+
+// UserStore is implemented by the caches in front of Repository. Reads go
+// through the cache, and writes reach the repository either synchronously
+// (Cache) or in batches (WriteBehindCache).
+type UserStore interface {
+	GetUsers() ([]User, error)
+	GetUser(name string) (User, error)
+	SaveUser(user User) error
 }
 
 type UserGetter struct {
@@ -29,43 +48,105 @@ func (u *UserGetter) GetUsers() ([]User, error) {
 	return nil, nil
 }
 
+func (u *UserGetter) GetUser(name string) (User, error) {
+	return User{}, ErrNotFound
+}
+
+func (u *UserGetter) SaveUsers(users []User) error {
+	return nil
+}
+
+// Cache puts each Repository method behind its own TypedCache. Writes are
+// write-through: the repository is updated first, then the cache.
+//
+// Hits are served from an in-process tier first. inv keeps it in sync with
+// the other instances and must be running for that to happen.
 type Cache struct {
 	client *redis.Client
 	repo   Repository
+	inv    *Invalidator
+	users  *TypedCache[[]User]
+	user   *TypedCache[User]
 }
 
-func NewCache(client *redis.Client, repo Repository) *Cache {
+// NewCache creates the caches of each Repository method. opts apply to all
+// of them, e.g. WithMetrics.
+func NewCache(client *redis.Client, repo Repository, inv *Invalidator, opts ...Option) *Cache {
+	// Expires in 1 minute. Listing users is expensive, so only one caller
+	// across all instances recomputes it while the rest are served the
+	// previous value.
+	usersOpts := []Option{
+		WithSingleflight(),
+		WithLock(5*time.Second, 2*time.Second),
+		WithStale(5 * time.Minute),
+		WithNegativeTTL(10 * time.Second),
+		WithStaleWhileRevalidate(1 * time.Minute),
+		WithEarlyExpiration(1),
+		WithTags(func(args ...interface{}) []string {
+			return []string{"users"}
+		}),
+		WithLocal(1, 5*time.Second, inv),
+		// The user list is large, so use a compact encoding.
+		WithCodec(MsgpackCodec{}),
+		WithCompression(1024),
+	}
+	userOpts := []Option{
+		WithSingleflight(),
+		WithNegativeTTL(10 * time.Second),
+		WithTags(func(args ...interface{}) []string {
+			return []string{"users", fmt.Sprintf("user:%v", args[0])}
+		}),
+		WithLocal(1000, 10*time.Second, inv),
+	}
 	return &Cache{
 		client: client,
 		repo:   repo,
+		inv:    inv,
+		users:  NewTypedCache[[]User](client, GetUsersCacheKey, 1*time.Minute, append(usersOpts, opts...)...),
+		user:   NewTypedCache[User](client, GetUserCacheKey, 1*time.Minute, append(userOpts, opts...)...),
 	}
 }
 
 func (c *Cache) GetUsers() ([]User, error) {
-	// Check the cache for values.
-	b, err := c.client.Get(GetUsersCacheKey).Bytes()
-	if err == redis.Nil || len(b) == 0 {
-		// Get and set if it does not exist.
-		res, err := c.repo.GetUsers()
-		if err != nil {
-			return nil, err
-		}
-		// Skip caching if no results are returned.
-		if len(res) == nil {
-			return nil, nil
-		}
-		b, err := json.Marshal(res)
-		if err != nil {
-			return nil, err
-		}
-		// Expires in 1 minute.
-		err = c.client.Set(GetUsersCacheKey, string(b), 1*time.Minute).Err()
-		return res, err
+	return c.users.Fetch(0, c.repo.GetUsers)
+}
+
+func (c *Cache) GetUser(name string) (User, error) {
+	return c.user.Fetch(0, func() (User, error) {
+		return c.repo.GetUser(name)
+	}, name)
+}
+
+func (c *Cache) SaveUser(user User) error {
+	if err := c.repo.SaveUsers([]User{user}); err != nil {
+		return err
+	}
+	return c.cacheUser(user)
+}
+
+// InvalidateTag drops every cached value tagged with tag, e.g. "users" or
+// "user:john".
+func (c *Cache) InvalidateTag(tag string) error {
+	keys, err := InvalidateTag(c.client, tag)
+	if err != nil {
+		return err
+	}
+	if c.inv == nil {
+		// Single instance, only its own in-process tiers hold copies.
+		c.users.removeLocal(keys...)
+		c.user.removeLocal(keys...)
+		return nil
+	}
+	return c.inv.Publish(keys...)
+}
+
+// cacheUser stores the written user and drops the user list, which no
+// longer matches the repository.
+func (c *Cache) cacheUser(user User) error {
+	if err := c.user.Set(user, 0, user.Name); err != nil {
+		return err
 	}
-	// Return if exists.
-	var res []User
-	err = json.Unmarshal(b, &res)
-	return res, err
+	return c.users.Delete()
 }
 
 func NewClient() *redis.Client {
@@ -79,6 +160,57 @@ func NewClient() *redis.Client {
 func main() {
 	client := NewClient()
 	repo := NewRepository()
-	repoWithCache := NewCache(client, repo)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Keep the in-process tiers in sync with the other instances.
+	inv := NewInvalidator(client)
+	go inv.Run(ctx)
+
+	// Expose the cache metrics for Prometheus to scrape.
+	metrics := NewMetrics(prometheus.DefaultRegisterer)
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Println(http.ListenAndServe(":8080", nil))
+	}()
+
+	repoWithCache := NewCache(client, repo, inv, WithMetrics(metrics))
 	repoWithCache.GetUsers()
+	repoWithCache.GetUser("john")
+
+	// Write-through: the repository and the cache are updated together.
+	if err := repoWithCache.SaveUser(User{Name: "john", Age: 20}); err != nil {
+		log.Println(err)
+	}
+
+	// Write-behind: writes are queued in Redis and flushed in batches by
+	// Run.
+	writeBehind := NewWriteBehindCache(client, repo, inv, WithMetrics(metrics))
+	flushCtx, stopFlush := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeBehind.Run(flushCtx)
+	}()
+	if err := writeBehind.SaveUser(User{Name: "jane", Age: 30}); err != nil {
+		log.Println(err)
+	}
+	fmt.Println(writeBehind.GetUser("jane"))
+	stopFlush()
+	<-done
+
+	// Drop everything derived from the users table.
+	if err := repoWithCache.InvalidateTag("users"); err != nil {
+		log.Println(err)
+	}
+
+	// Any other loader can be cached the same way, with the key derived
+	// from its arguments.
+	usersByAge := NewTypedCache[[]User](client, "ServerName:UserGetter:GetUsersByAge", 5*time.Minute,
+		WithMetrics(metrics),
+	)
+	getUsersByAge := Wrap1(usersByAge, 0, func(age int64) ([]User, error) {
+		return []User{{Name: "john", Age: age}}, nil
+	})
+	fmt.Println(getUsersByAge(20))
 }
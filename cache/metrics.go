@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records how well each TypedCache performs, labelled by key prefix
+// so that the TTL of every repository method can be tuned separately. A nil
+// *Metrics records nothing.
+type Metrics struct {
+	hits         *prometheus.CounterVec
+	misses       *prometheus.CounterVec
+	loads        *prometheus.CounterVec
+	loadErrors   *prometheus.CounterVec
+	loadDuration *prometheus.HistogramVec
+	payloadSize  *prometheus.HistogramVec
+}
+
+// NewMetrics creates the cache metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_hits_total",
+			Help: "Number of lookups served from the cache, by tier.",
+		}, []string{"prefix", "tier"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_misses_total",
+			Help: "Number of lookups not found in the cache.",
+		}, []string{"prefix"}),
+		loads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_loads_total",
+			Help: "Number of calls to the loader.",
+		}, []string{"prefix"}),
+		loadErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_load_errors_total",
+			Help: "Number of loader calls that failed, not counting ErrNotFound.",
+		}, []string{"prefix"}),
+		loadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_load_duration_seconds",
+			Help:    "Time spent in the loader.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"prefix"}),
+		payloadSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_payload_bytes",
+			Help:    "Size of the encoded values written to Redis.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"prefix"}),
+	}
+	reg.MustRegister(m.hits, m.misses, m.loads, m.loadErrors, m.loadDuration, m.payloadSize)
+	return m
+}
+
+func (m *Metrics) hit(prefix, tier string) {
+	if m == nil {
+		return
+	}
+	m.hits.WithLabelValues(prefix, tier).Inc()
+}
+
+func (m *Metrics) miss(prefix string) {
+	if m == nil {
+		return
+	}
+	m.misses.WithLabelValues(prefix).Inc()
+}
+
+func (m *Metrics) load(prefix string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.loads.WithLabelValues(prefix).Inc()
+	m.loadDuration.WithLabelValues(prefix).Observe(d.Seconds())
+	if err != nil {
+		m.loadErrors.WithLabelValues(prefix).Inc()
+	}
+}
+
+func (m *Metrics) payload(prefix string, n int) {
+	if m == nil {
+		return
+	}
+	m.payloadSize.WithLabelValues(prefix).Observe(float64(n))
+}
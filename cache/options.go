@@ -0,0 +1,160 @@
+package main
+
+import "time"
+
+// Option configures a TypedCache.
+type Option func(*options)
+
+type options struct {
+	// singleflight coalesces concurrent loads of the same key within this
+	// process into a single call.
+	singleflight bool
+
+	// lockTTL enables the distributed recompute lock when positive. Only the
+	// instance holding the lock calls the loader; the others serve the stale
+	// copy or wait up to lockWait for the value to appear.
+	lockTTL  time.Duration
+	lockWait time.Duration
+
+	// staleTTL keeps a copy of every value for this long past its TTL, to be
+	// served while another instance holds the lock.
+	staleTTL time.Duration
+
+	// negativeTTL caches empty results and ErrNotFound for this long when
+	// positive. Otherwise they are not cached at all.
+	negativeTTL time.Duration
+
+	// swrTTL keeps values in Redis for this long past their logical expiry.
+	// Callers in that window get the stale value while a single background
+	// goroutine refreshes it.
+	swrTTL time.Duration
+
+	// beta enables probabilistic early expiration when positive. Larger
+	// values refresh earlier.
+	beta float64
+
+	// tags returns the tags of the value cached for the call arguments.
+	tags func(args ...interface{}) []string
+
+	// localSize enables the in-process tier when positive. Entries expire
+	// after localTTL, and are dropped early when the invalidator receives a
+	// write from any instance.
+	localSize   int
+	localTTL    time.Duration
+	invalidator *Invalidator
+
+	// codec encodes new values. Existing values are decoded with the codec
+	// recorded in their header.
+	codec Codec
+
+	// compressThreshold compresses encoded values of at least this many
+	// bytes when positive.
+	compressThreshold int
+
+	// metrics records hits, misses and loads when not nil.
+	metrics *Metrics
+}
+
+// envelope reports whether values are stored with their logical expiry.
+func (o options) envelope() bool {
+	return o.swrTTL > 0 || o.beta > 0
+}
+
+// WithSingleflight coalesces concurrent in-process loads of the same key.
+func WithSingleflight() Option {
+	return func(o *options) {
+		o.singleflight = true
+	}
+}
+
+// WithLock lets only one instance recompute an expired key at a time. The
+// lock expires after ttl in case the holder dies, and callers that did not
+// get the lock wait at most wait before loading the value themselves.
+func WithLock(ttl, wait time.Duration) Option {
+	return func(o *options) {
+		o.lockTTL = ttl
+		o.lockWait = wait
+	}
+}
+
+// WithStale keeps values around for ttl after they expire so that callers
+// losing the lock race can be served the stale value instead of waiting.
+func WithStale(ttl time.Duration) Option {
+	return func(o *options) {
+		o.staleTTL = ttl
+	}
+}
+
+// WithNegativeTTL caches empty results and ErrNotFound from the loader for
+// ttl, which is usually much shorter than the TTL of real values. This stops
+// repeated lookups for absent data from reaching the repository.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithStaleWhileRevalidate serves values for up to ttl past their expiry
+// while they are refreshed in the background, instead of making the caller
+// wait for the loader.
+func WithStaleWhileRevalidate(ttl time.Duration) Option {
+	return func(o *options) {
+		o.swrTTL = ttl
+	}
+}
+
+// WithEarlyExpiration refreshes values in the background before they expire,
+// with a probability that rises as the expiry approaches. A beta of 1 is a
+// good default, so that hot keys never expire under load.
+func WithEarlyExpiration(beta float64) Option {
+	return func(o *options) {
+		o.beta = beta
+	}
+}
+
+// WithTags attaches the tags returned by fn for the call arguments to every
+// value written to the cache, so that InvalidateTag can drop them without
+// knowing their keys.
+func WithTags(fn func(args ...interface{}) []string) Option {
+	return func(o *options) {
+		o.tags = fn
+	}
+}
+
+// WithLocal adds an in-process LRU tier of up to size entries in front of
+// Redis, saving the round trip and decoding on hits. Entries are kept for at
+// most ttl, so it also bounds how stale a copy can get if an invalidation is
+// missed. Writes made through Set, Delete or InvalidateTag on any instance
+// evict the local copies on all instances running inv. inv may be nil when
+// there is a single instance.
+func WithLocal(size int, ttl time.Duration, inv *Invalidator) Option {
+	return func(o *options) {
+		o.localSize = size
+		o.localTTL = ttl
+		o.invalidator = inv
+	}
+}
+
+// WithCodec encodes new values with codec instead of JSON. Values already in
+// Redis keep being readable, since each records the codec it was written
+// with.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithCompression compresses encoded values of at least threshold bytes
+// with snappy.
+func WithCompression(threshold int) Option {
+	return func(o *options) {
+		o.compressThreshold = threshold
+	}
+}
+
+// WithMetrics records the hits, misses and loads of the cache in m.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
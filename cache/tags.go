@@ -0,0 +1,71 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const TagKeyPrefix = "ServerName:Tag:"
+
+// addTagScript adds ARGV[2..] to the tag set and extends its expiry to at
+// least ARGV[1] milliseconds, so that the set outlives every key in it but
+// does not grow forever. A ttl of zero or less is for keys without expiry,
+// and makes the set persistent too.
+var addTagScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1]) == 1
+redis.call("SADD", KEYS[1], unpack(ARGV, 2))
+local ttl = tonumber(ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if not existed or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+
+// invalidateTagScript deletes every key in the tag set and the set itself,
+// and returns the deleted keys. Keys are deleted in chunks to stay below the
+// Lua unpack limit.
+var invalidateTagScript = redis.NewScript(`
+local keys = redis.call("SMEMBERS", KEYS[1])
+for i = 1, #keys, 1000 do
+	redis.call("DEL", unpack(keys, i, math.min(i + 999, #keys)))
+end
+redis.call("DEL", KEYS[1])
+return keys
+`)
+
+func tagKey(tag string) string {
+	return TagKeyPrefix + tag
+}
+
+// addTag queues the tagging of keys on pipe. The script is sent with EVAL
+// rather than EVALSHA, since a NOSCRIPT error cannot be retried inside a
+// transaction.
+func addTag(pipe redis.Pipeliner, tag string, ttl time.Duration, keys ...string) {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, ttl.Milliseconds())
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	addTagScript.Eval(pipe, []string{tagKey(tag)}, args...)
+}
+
+// InvalidateTag atomically deletes every key tagged with tag and returns the
+// deleted keys.
+func InvalidateTag(client *redis.Client, tag string) ([]string, error) {
+	res, err := invalidateTagScript.Run(client, []string{tagKey(tag)}).Result()
+	if err != nil {
+		return nil, err
+	}
+	items, _ := res.([]interface{})
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i], _ = item.(string)
+	}
+	return keys, nil
+}
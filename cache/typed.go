@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/sync/singleflight"
+)
+
+// Sentinels stored in place of a value by negative caching. They start with
+// a NUL byte so they can never collide with a JSON payload.
+const (
+	emptyValue    = "\x00empty"
+	notFoundValue = "\x00notfound"
+)
+
+// ErrNotFound is returned by loaders when the requested value does not
+// exist. With negative caching enabled it is cached like any other result.
+var ErrNotFound = errors.New("not found")
+
+// entry is stored next to a value when stale-while-revalidate or early
+// expiration is enabled.
+type entry struct {
+	// Expiry is the logical expiry in unix milliseconds. The key itself
+	// lives on in Redis for a while after that.
+	Expiry int64
+	// Delta is how long the loader took in milliseconds.
+	Delta int64
+}
+
+// expired reports whether the entry should be recomputed at now. With a
+// positive beta, it may return true before the logical expiry, with a
+// probability that grows as the expiry nears and the longer the loader
+// takes (XFetch, from "Optimal Probabilistic Cache Stampede Prevention").
+func (e entry) expired(now time.Time, beta float64) bool {
+	ms := now.UnixMilli()
+	if beta > 0 {
+		// 1 - rand.Float64() is in (0, 1], so the log is never -Inf.
+		ms -= int64(float64(e.Delta) * beta * math.Log(1-rand.Float64()))
+	}
+	return ms >= e.Expiry
+}
+
+// TypedCache implements cache-aside for any loader returning T. Values are
+// encoded with the configured Codec, JSON by default, under keys derived
+// from the cache prefix and the call arguments, so one TypedCache can sit in
+// front of a repository method regardless of its parameters.
+type TypedCache[T any] struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	opts   options
+	group  singleflight.Group
+
+	// refreshing holds the keys being revalidated in the background.
+	refreshing sync.Map
+
+	// local is the optional in-process tier in front of Redis.
+	local *lru[localValue[T]]
+}
+
+// localValue is a result held by the in-process tier, including a cached
+// ErrNotFound.
+type localValue[T any] struct {
+	res T
+	err error
+}
+
+func NewTypedCache[T any](client *redis.Client, prefix string, ttl time.Duration, opts ...Option) *TypedCache[T] {
+	c := &TypedCache[T]{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+		opts: options{
+			codec: JSONCodec{},
+		},
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	if c.opts.localSize > 0 {
+		c.local = newLRU[localValue[T]](c.opts.localSize, c.opts.localTTL)
+		if c.opts.invalidator != nil {
+			c.opts.invalidator.register(c.local)
+		}
+	}
+	return c
+}
+
+// keyEscaper escapes the separator in arguments, so that ("a", "b:c") and
+// ("a:b", "c") do not share a key.
+var keyEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+// Key returns the cache key for the given call arguments, e.g.
+// ServerName:UserGetter:GetUser:john. Without arguments the prefix itself is
+// the key.
+func (c *TypedCache[T]) Key(args ...interface{}) string {
+	if len(args) == 0 {
+		return c.prefix
+	}
+	parts := make([]string, len(args)+1)
+	parts[0] = c.prefix
+	for i, arg := range args {
+		parts[i+1] = keyEscaper.Replace(fmt.Sprint(arg))
+	}
+	return strings.Join(parts, ":")
+}
+
+// Fetch returns the value cached for args. On a miss, load is called and
+// its result is cached for ttl. A zero ttl falls back to the cache default.
+func (c *TypedCache[T]) Fetch(ttl time.Duration, load func() (T, error), args ...interface{}) (T, error) {
+	key := c.Key(args...)
+	if c.local == nil {
+		return c.fetch(key, args, ttl, load)
+	}
+	if v, ok := c.local.Get(key); ok {
+		c.opts.metrics.hit(c.prefix, "local")
+		return v.res, v.err
+	}
+	res, err := c.fetch(key, args, ttl, load)
+	// Only keep what Redis would have kept.
+	negative := errors.Is(err, ErrNotFound) || (err == nil && isEmpty(res))
+	if (err == nil && !negative) || (negative && c.opts.negativeTTL > 0) {
+		c.local.Set(key, localValue[T]{res, err})
+	}
+	return res, err
+}
+
+// fetch is Fetch without the in-process tier.
+func (c *TypedCache[T]) fetch(key string, args []interface{}, ttl time.Duration, load func() (T, error)) (T, error) {
+	tags := c.tags(args)
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	res, ok, refresh, err := c.get(key)
+	if refresh {
+		c.revalidate(key, tags, ttl, load)
+	}
+	if ok {
+		c.opts.metrics.hit(c.prefix, "redis")
+		return res, err
+	}
+	if err != nil {
+		return res, err
+	}
+	c.opts.metrics.miss(c.prefix)
+	if !c.opts.singleflight {
+		return c.fill(key, tags, ttl, load)
+	}
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		return c.fill(key, tags, ttl, load)
+	})
+	res, _ = v.(T)
+	return res, err
+}
+
+// fill loads the value for a missing key, holding the distributed lock if
+// one is configured.
+func (c *TypedCache[T]) fill(key string, tags []string, ttl time.Duration, load func() (T, error)) (T, error) {
+	if c.opts.lockTTL <= 0 {
+		return c.load(key, tags, ttl, load)
+	}
+	token, ok, err := acquire(c.client, key, c.opts.lockTTL)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if ok {
+		defer release(c.client, key, token)
+		// Another instance may have filled the key between our miss and
+		// taking the lock.
+		if res, ok, _, err := c.get(key); err != nil || ok {
+			return res, err
+		}
+		return c.load(key, tags, ttl, load)
+	}
+
+	// Another instance is recomputing the value. Serve the stale copy if
+	// there is one, otherwise wait for the value to show up.
+	if c.opts.staleTTL > 0 {
+		if res, ok, _, err := c.get(staleKey(key)); err != nil || ok {
+			return res, err
+		}
+	}
+	deadline := time.Now().Add(c.opts.lockWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(lockPollInterval)
+		if res, ok, _, err := c.get(key); err != nil || ok {
+			return res, err
+		}
+	}
+	// The lock holder is taking too long or has died, load it ourselves.
+	return c.load(key, tags, ttl, load)
+}
+
+// revalidate refreshes key in the background while callers keep being served
+// the current value. At most one refresh per key runs in this process, and
+// with the lock enabled at most one across all instances.
+func (c *TypedCache[T]) revalidate(key string, tags []string, ttl time.Duration, load func() (T, error)) {
+	if _, loaded := c.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer c.refreshing.Delete(key)
+		if c.opts.lockTTL > 0 {
+			token, ok, err := acquire(c.client, key, c.opts.lockTTL)
+			if err != nil || !ok {
+				return
+			}
+			defer release(c.client, key, token)
+		}
+		if _, err := c.load(key, tags, ttl, load); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Println("cache: revalidate failed", key, err)
+		}
+	}()
+}
+
+// load calls the loader and caches its result.
+func (c *TypedCache[T]) load(key string, tags []string, ttl time.Duration, load func() (T, error)) (T, error) {
+	start := time.Now()
+	res, err := load()
+	delta := time.Since(start)
+	if errors.Is(err, ErrNotFound) {
+		c.opts.metrics.load(c.prefix, delta, nil)
+	} else {
+		c.opts.metrics.load(c.prefix, delta, err)
+	}
+	if errors.Is(err, ErrNotFound) && c.opts.negativeTTL > 0 {
+		if err := c.store(key, tags, []byte(notFoundValue), c.opts.negativeTTL, 0); err != nil {
+			return res, err
+		}
+		return res, ErrNotFound
+	}
+	if err != nil {
+		return res, err
+	}
+	if isEmpty(res) {
+		// Skip caching if no results are returned, unless negative caching
+		// is enabled.
+		if c.opts.negativeTTL <= 0 {
+			return res, nil
+		}
+		return res, c.store(key, tags, []byte(emptyValue), c.opts.negativeTTL, 0)
+	}
+	return res, c.set(key, tags, res, ttl, delta)
+}
+
+// get returns the cached value for key and whether it was found. A cached
+// empty result is found with the zero value, and a cached not-found result
+// is found with ErrNotFound. refresh reports that the value is past its
+// logical expiry, or was picked for early expiration, and should be
+// revalidated.
+func (c *TypedCache[T]) get(key string) (res T, found, refresh bool, err error) {
+	b, err := c.client.Get(key).Bytes()
+	if err == redis.Nil {
+		return res, false, false, nil
+	}
+	if err != nil || len(b) == 0 {
+		return res, false, false, err
+	}
+	switch string(b) {
+	case emptyValue:
+		return res, true, false, nil
+	case notFoundValue:
+		return res, true, false, ErrNotFound
+	}
+	var e *entry
+	if b[0]&headerFramed == 0 {
+		err = decodeLegacy(b, &res)
+	} else {
+		e, err = decode(c.opts.codec, b, &res)
+	}
+	if err != nil {
+		return res, false, false, err
+	}
+	return res, true, e != nil && e.expired(time.Now(), c.opts.beta), nil
+}
+
+func (c *TypedCache[T]) set(key string, tags []string, res T, ttl, delta time.Duration) error {
+	// Keep the value in Redis past its logical expiry so that it can be
+	// served while it is being revalidated.
+	var e *entry
+	hardTTL := ttl
+	if c.opts.envelope() {
+		e = &entry{
+			Expiry: time.Now().Add(ttl).UnixMilli(),
+			Delta:  delta.Milliseconds(),
+		}
+		hardTTL += c.opts.swrTTL
+	}
+	b, err := encode(c.opts.codec, res, e, c.opts.compressThreshold)
+	if err != nil {
+		return err
+	}
+	c.opts.metrics.payload(c.prefix, len(b))
+	return c.store(key, tags, b, hardTTL, c.opts.staleTTL)
+}
+
+// store writes the encoded value, its stale copy when staleTTL is positive,
+// and the tag membership of both in a single transaction.
+func (c *TypedCache[T]) store(key string, tags []string, b []byte, ttl, staleTTL time.Duration) error {
+	_, err := c.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(key, b, ttl)
+		keys := []string{key}
+		if staleTTL > 0 {
+			pipe.Set(staleKey(key), b, ttl+staleTTL)
+			keys = append(keys, staleKey(key))
+		}
+		// The tag set must outlive the keys, which do not expire when ttl
+		// is zero.
+		tagTTL := ttl + staleTTL
+		if ttl <= 0 {
+			tagTTL = 0
+		}
+		for _, tag := range tags {
+			addTag(pipe, tag, tagTTL, keys...)
+		}
+		return nil
+	})
+	return err
+}
+
+// tags returns the tags of the value cached for args.
+func (c *TypedCache[T]) tags(args []interface{}) []string {
+	if c.opts.tags == nil {
+		return nil
+	}
+	return c.opts.tags(args...)
+}
+
+// Set caches res for args, e.g. after it was written to the repository. A
+// zero ttl falls back to the cache default.
+func (c *TypedCache[T]) Set(res T, ttl time.Duration, args ...interface{}) error {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	key := c.Key(args...)
+	if err := c.set(key, c.tags(args), res, ttl, 0); err != nil {
+		return err
+	}
+	return c.invalidate(key)
+}
+
+// Delete removes the value cached for args.
+func (c *TypedCache[T]) Delete(args ...interface{}) error {
+	key := c.Key(args...)
+	if err := c.client.Del(key, staleKey(key)).Err(); err != nil {
+		return err
+	}
+	return c.invalidate(key)
+}
+
+// invalidate drops key from the in-process tier of every instance.
+func (c *TypedCache[T]) invalidate(key string) error {
+	if c.local == nil {
+		return nil
+	}
+	if c.opts.invalidator == nil {
+		c.removeLocal(key)
+		return nil
+	}
+	return c.opts.invalidator.Publish(key)
+}
+
+// removeLocal drops keys from the in-process tier of this instance only.
+func (c *TypedCache[T]) removeLocal(keys ...string) {
+	if c.local != nil {
+		c.local.Remove(keys...)
+	}
+}
+
+// Wrap returns fn with its result cached for ttl.
+func Wrap[T any](c *TypedCache[T], ttl time.Duration, fn func() (T, error)) func() (T, error) {
+	return func() (T, error) {
+		return c.Fetch(ttl, fn)
+	}
+}
+
+// Wrap1 returns fn with its result cached for ttl under a key derived from
+// the argument.
+func Wrap1[A, T any](c *TypedCache[T], ttl time.Duration, fn func(A) (T, error)) func(A) (T, error) {
+	return func(a A) (T, error) {
+		return c.Fetch(ttl, func() (T, error) {
+			return fn(a)
+		}, a)
+	}
+}
+
+// Wrap2 is Wrap1 for loaders taking two arguments.
+func Wrap2[A, B, T any](c *TypedCache[T], ttl time.Duration, fn func(A, B) (T, error)) func(A, B) (T, error) {
+	return func(a A, b B) (T, error) {
+		return c.Fetch(ttl, func() (T, error) {
+			return fn(a, b)
+		}, a, b)
+	}
+}
+
+// isEmpty reports whether v is a nil or zero-length result.
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
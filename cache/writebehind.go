@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	WriteQueueKey      = "ServerName:UserGetter:WriteQueue"
+	WriteProcessingKey = "ServerName:UserGetter:WriteQueue:processing"
+	WriteAttemptsKey   = "ServerName:UserGetter:WriteQueue:attempts"
+	WriteDeadLetterKey = "ServerName:UserGetter:WriteQueue:dead"
+)
+
+// popBatchScript moves up to ARGV[1] writes from the queue into the
+// processing list and returns them. If the processing list is not empty, a
+// previous flush failed or crashed and its batch is returned again instead.
+var popBatchScript = redis.NewScript(`
+local pending = redis.call("LRANGE", KEYS[2], 0, -1)
+if #pending > 0 then
+	return pending
+end
+local items = redis.call("LRANGE", KEYS[1], 0, tonumber(ARGV[1]) - 1)
+if #items == 0 then
+	return items
+end
+redis.call("LTRIM", KEYS[1], #items, -1)
+redis.call("RPUSH", KEYS[2], unpack(items))
+return items
+`)
+
+// deadLetterScript moves the batch in the processing list to the dead-letter
+// list and resets its attempt count.
+var deadLetterScript = redis.NewScript(`
+local items = redis.call("LRANGE", KEYS[1], 0, -1)
+if #items > 0 then
+	redis.call("RPUSH", KEYS[2], unpack(items))
+end
+redis.call("DEL", KEYS[1], KEYS[3])
+return #items
+`)
+
+// WriteBehindCache updates the cache immediately and queues writes in a
+// Redis list, which Run flushes to the repository in batches. Writes are not
+// lost if the process dies, but the repository lags behind the cache.
+//
+// A batch the repository keeps rejecting is moved to a dead-letter list
+// after maxAttempts flushes, so that it does not hold up the writes queued
+// after it. Writes in the dead-letter list are never retried automatically.
+//
+// Only one flusher should run per queue.
+type WriteBehindCache struct {
+	*Cache
+	client      *redis.Client
+	batchSize   int64
+	interval    time.Duration
+	maxRetries  int
+	backoff     time.Duration
+	maxAttempts int64
+}
+
+func NewWriteBehindCache(client *redis.Client, repo Repository, inv *Invalidator, opts ...Option) *WriteBehindCache {
+	return &WriteBehindCache{
+		Cache:       NewCache(client, repo, inv, opts...),
+		client:      client,
+		batchSize:   100,
+		interval:    1 * time.Second,
+		maxRetries:  3,
+		backoff:     100 * time.Millisecond,
+		maxAttempts: 5,
+	}
+}
+
+func (c *WriteBehindCache) SaveUser(user User) error {
+	b, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	// Queue the write before caching it, so that a cached value is never
+	// missing from the repository for good.
+	if err := c.client.RPush(WriteQueueKey, b).Err(); err != nil {
+		return err
+	}
+	return c.cacheUser(user)
+}
+
+// Run flushes the queue every interval until ctx is done, then flushes it
+// one last time.
+func (c *WriteBehindCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.Flush(); err != nil {
+				log.Println("write-behind: flush failed", err)
+			}
+			return
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				log.Println("write-behind: flush failed", err)
+			}
+		}
+	}
+}
+
+// Flush writes queued users to the repository until the queue is empty. A
+// batch that still fails after maxRetries stays in the processing list and
+// is retried on the next flush, up to maxAttempts flushes.
+func (c *WriteBehindCache) Flush() error {
+	for {
+		n, err := c.flushBatch()
+		if err != nil {
+			return err
+		}
+		if n < c.batchSize {
+			return nil
+		}
+	}
+}
+
+func (c *WriteBehindCache) flushBatch() (int64, error) {
+	res, err := popBatchScript.Run(c.client, []string{WriteQueueKey, WriteProcessingKey}, c.batchSize).Result()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	items, _ := res.([]interface{})
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	users := make([]User, 0, len(items))
+	for _, item := range items {
+		s, _ := item.(string)
+		var user User
+		if err := json.Unmarshal([]byte(s), &user); err != nil {
+			// Retrying will not fix a malformed write.
+			log.Println("write-behind: dropping malformed write", item, err)
+			continue
+		}
+		users = append(users, user)
+	}
+
+	if len(users) == 0 {
+		// Every write was malformed, there is nothing to save.
+		return int64(len(items)), c.client.Del(WriteProcessingKey, WriteAttemptsKey).Err()
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err = c.repo.SaveUsers(users)
+		if err == nil {
+			break
+		}
+		if attempt == c.maxRetries {
+			return c.failBatch(len(items), users, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	// Acknowledge the batch.
+	if err := c.client.Del(WriteProcessingKey, WriteAttemptsKey).Err(); err != nil {
+		return 0, err
+	}
+	// SaveUser dropped the user list before the writes reached the
+	// repository, so a read in between may have cached it without them.
+	if err := c.users.Delete(); err != nil {
+		return 0, err
+	}
+	return int64(len(items)), nil
+}
+
+// failBatch counts a failed flush of the batch in the processing list, and
+// moves the batch to the dead-letter list once it has failed maxAttempts
+// times, so that the rest of the queue can be flushed. The cached copies of
+// the users in the batch are dropped, since the repository rejected them.
+func (c *WriteBehindCache) failBatch(n int, users []User, err error) (int64, error) {
+	attempts, incrErr := c.client.Incr(WriteAttemptsKey).Result()
+	if incrErr != nil || attempts < c.maxAttempts {
+		return 0, err
+	}
+	if err := deadLetterScript.Run(c.client, []string{WriteProcessingKey, WriteDeadLetterKey, WriteAttemptsKey}).Err(); err != nil {
+		return 0, err
+	}
+	log.Println("write-behind: moved batch to", WriteDeadLetterKey, "after", attempts, "attempts:", err)
+	for _, user := range users {
+		if err := c.user.Delete(user.Name); err != nil {
+			return 0, err
+		}
+	}
+	return int64(n), c.users.Delete()
+}
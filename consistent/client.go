@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+var ErrNoNodes = errors.New("consistent: no nodes")
+
+// ShardedClient routes each key to one of several Redis nodes through a
+// Ring, so that caches and counters can scale out without Redis Cluster.
+// Commands touching several keys only work if all of them live on the same
+// node.
+type ShardedClient struct {
+	// changeMu serializes AddNode and RemoveNode, which do slow work
+	// without holding mu so that Client is never blocked by it.
+	changeMu sync.Mutex
+
+	mu      sync.RWMutex
+	ring    *Ring
+	clients map[string]*redis.Client
+}
+
+func NewShardedClient(replicas int) *ShardedClient {
+	return &ShardedClient{
+		ring:    NewRing(replicas),
+		clients: make(map[string]*redis.Client),
+	}
+}
+
+// Client returns the client of the node owning key, or ErrNoNodes if no
+// node has been added yet.
+func (s *ShardedClient) Client(key string) (*redis.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[s.ring.Get(key)]
+	if !ok {
+		return nil, ErrNoNodes
+	}
+	return client, nil
+}
+
+// AddNode adds a node with the given weight and returns the number of
+// existing keys that now belong to it. Those keys are not copied: until
+// they are migrated or repopulated, reads for them will miss.
+func (s *ShardedClient) AddNode(name string, client *redis.Client, weight int) (int64, error) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	s.mu.RLock()
+	_, ok := s.clients[name]
+	next := s.ring.Clone()
+	clients := make([]*redis.Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.RUnlock()
+	if ok {
+		return 0, fmt.Errorf("consistent: node %q already exists", name)
+	}
+	if err := next.Add(name, weight); err != nil {
+		return 0, err
+	}
+
+	// Scanning every node takes a while, keep serving the current ring in
+	// the meantime.
+	var moved int64
+	for _, c := range clients {
+		n, err := countMoved(c, next, name)
+		if err != nil {
+			return 0, err
+		}
+		moved += n
+	}
+
+	s.mu.Lock()
+	s.ring = next
+	s.clients[name] = client
+	s.mu.Unlock()
+	return moved, nil
+}
+
+// RemoveNode removes a node and returns the number of keys it held, all of
+// which now belong to other nodes.
+func (s *ShardedClient) RemoveNode(name string) (int64, error) {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+
+	s.mu.RLock()
+	client, ok := s.clients[name]
+	next := s.ring.Clone()
+	s.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("consistent: node %q does not exist", name)
+	}
+	moved, err := client.DBSize().Result()
+	if err != nil {
+		return 0, err
+	}
+	next.Remove(name)
+
+	s.mu.Lock()
+	s.ring = next
+	delete(s.clients, name)
+	s.mu.Unlock()
+	return moved, nil
+}
+
+// countMoved scans the keys of client and counts the ones owned by node in
+// ring.
+func countMoved(client *redis.Client, ring *Ring, node string) (int64, error) {
+	var moved int64
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(cursor, "*", 1000).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			if ring.Get(key) == node {
+				moved++
+			}
+		}
+		if next == 0 {
+			return moved, nil
+		}
+		cursor = next
+	}
+}
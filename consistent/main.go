@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/go-redis/redis"
+)
+
+func NewClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+}
+
+func main() {
+	// How evenly do keys spread, and how many move when a node joins?
+	ring := NewRing(100)
+	for node, weight := range map[string]int{"redis-a": 1, "redis-b": 1, "redis-c": 2} {
+		if err := ring.Add(node, weight); err != nil {
+			log.Fatal(err)
+		}
+	}
+	displayDistribution(ring)
+
+	next := ring.Clone()
+	if err := next.Add("redis-d", 1); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("adding redis-d moves %.1f%% of the keys\n", Moved(ring, next)*100)
+	displayDistribution(next)
+
+	// Route the keys to actual Redis nodes.
+	sharded := NewShardedClient(100)
+	for _, addr := range []string{"localhost:6379", "localhost:6380"} {
+		if _, err := sharded.AddNode(addr, NewClient(addr), 1); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := "counter:" + strconv.Itoa(i)
+		client, err := sharded.Client(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := client.Incr(key).Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	moved, err := sharded.AddNode("localhost:6381", NewClient("localhost:6381"), 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("keys moved to localhost:6381", moved)
+}
+
+func displayDistribution(ring *Ring) {
+	counts := make(map[string]int)
+	for i := 0; i < 100000; i++ {
+		counts[ring.Get("key:"+strconv.Itoa(i))]++
+	}
+	fmt.Println(ring.Nodes(), counts)
+}
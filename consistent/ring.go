@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Ring maps keys to nodes with consistent hashing. Each node is placed on
+// the ring as replicas*weight virtual nodes, so that keys spread evenly and
+// a node with weight 2 receives about twice the keys of a node with weight 1.
+// Adding or removing a node only moves the keys of the arcs it gains or
+// loses, instead of remapping almost every key like hash(key) % n.
+type Ring struct {
+	mu       sync.RWMutex
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+	weights  map[string]int
+}
+
+func NewRing(replicas int) *Ring {
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		weights:  make(map[string]int),
+	}
+}
+
+// hash uses md5 like consistent.js. It is not used for security, but unlike
+// CRC32 it spreads similar keys such as "node:1" and "node:2" evenly.
+func hash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Add places node on the ring, replacing it if it already exists. The weight
+// must be positive, a node with no virtual nodes would never own a key.
+func (r *Ring) Add(node string, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("consistent: invalid weight %d for node %q", weight, node)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(node)
+	r.weights[node] = weight
+	for i := 0; i < r.replicas*weight; i++ {
+		h := hash(node + ":" + strconv.Itoa(i))
+		// On the rare collision the first node keeps the point.
+		if _, ok := r.owners[h]; ok {
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return nil
+}
+
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(node)
+}
+
+func (r *Ring) remove(node string) {
+	if _, ok := r.weights[node]; !ok {
+		return
+	}
+	delete(r.weights, node)
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// Get returns the node owning key, which is the first virtual node clockwise
+// from the hash of the key. It returns an empty string if the ring is empty.
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(hash(key))
+}
+
+func (r *Ring) get(h uint32) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Nodes returns the nodes on the ring with their weights.
+func (r *Ring) Nodes() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make(map[string]int, len(r.weights))
+	for node, weight := range r.weights {
+		nodes[node] = weight
+	}
+	return nodes
+}
+
+// Clone returns a copy of the ring, e.g. to preview the effect of adding or
+// removing a node.
+func (r *Ring) Clone() *Ring {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := NewRing(r.replicas)
+	c.hashes = append(c.hashes, r.hashes...)
+	for h, node := range r.owners {
+		c.owners[h] = node
+	}
+	for node, weight := range r.weights {
+		c.weights[node] = weight
+	}
+	return c
+}
+
+// Moved returns the fraction of the hash space, and so roughly of the keys,
+// owned by a different node in after than in before.
+func Moved(before, after *Ring) float64 {
+	before.mu.RLock()
+	defer before.mu.RUnlock()
+	after.mu.RLock()
+	defer after.mu.RUnlock()
+
+	if len(before.hashes) == 0 && len(after.hashes) == 0 {
+		return 0
+	}
+	if len(before.hashes) == 0 || len(after.hashes) == 0 {
+		return 1
+	}
+
+	// Between two consecutive points of either ring the owner in both rings
+	// is constant, so it is enough to compare the owner of each arc.
+	points := make([]uint32, 0, len(before.hashes)+len(after.hashes))
+	points = append(points, before.hashes...)
+	points = append(points, after.hashes...)
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	unique := points[:1]
+	for _, p := range points[1:] {
+		if p != unique[len(unique)-1] {
+			unique = append(unique, p)
+		}
+	}
+	if len(unique) == 1 {
+		if before.get(unique[0]) != after.get(unique[0]) {
+			return 1
+		}
+		return 0
+	}
+
+	var moved uint64
+	prev := unique[len(unique)-1]
+	for _, p := range unique {
+		// The arc (prev, p] wraps around zero for the first point.
+		if before.get(p) != after.get(p) {
+			moved += uint64(p - prev)
+		}
+		prev = p
+	}
+	return float64(moved) / (1 << 32)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/go-redis/redis"
 )
@@ -11,28 +13,156 @@ type MediaID string
 type LikeManager interface {
 	Like(mediaID, userID string) error
 	Unlike(mediaID, userID string) error
-	Count(mediaID string) int64
+	Count(mediaID string) (int64, bool)
+	Liked(mediaID, userID string) (bool, error)
+	LikedAt(mediaID, userID string) (time.Time, bool, error)
+	LikedPosts(userID, cursor string, limit int64) ([]LikedPost, string, error)
 }
 
+// eventStreamMaxLen is the MAXLEN ~ the like scripts pass to XADD, which
+// keeps the like events stream bounded even if nothing consumes it.
+const eventStreamMaxLen = 100000
+
+// likeScript adds the user to the likers of the post and the post to the
+// likes of the user, scored by ARGV[6] in milliseconds. If the user had not
+// liked it yet, it also increments the like counter of sharded posts when
+// ARGV[4] is "1", and appends the change to the stream KEYS[6] if given, in
+// the same atomic step.
+//
+// Once the post has ARGV[5] likes, new likers are added to the HyperLogLog
+// KEYS[3] instead of the set, see approx.go.
+//
+// Sharded posts liked before sharding was enabled also check the legacy set
+// KEYS[5], see shard.go.
+//
+// KEYS: set, counter, hyperloglog, user likes, legacy set, stream (optional)
+// ARGV: user ID, stream max length, post ID, whether to count, threshold, now
+var likeScript = redis.NewScript(`
+local sharded = ARGV[4] == "1"
+if sharded then
+	if redis.call("EXISTS", KEYS[2]) == 0 then
+		redis.call("SET", KEYS[2], redis.call("SCARD", KEYS[5]))
+	end
+	if redis.call("SISMEMBER", KEYS[5], ARGV[1]) == 1 then
+		return 0
+	end
+end
+if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 or redis.call("ZSCORE", KEYS[4], ARGV[3]) then
+	return 0
+end
+local approx = redis.call("EXISTS", KEYS[3]) == 1
+local threshold = tonumber(ARGV[5])
+if not approx and threshold > 0 then
+	local count
+	if sharded then
+		count = tonumber(redis.call("GET", KEYS[2]))
+	else
+		count = redis.call("SCARD", KEYS[1])
+	end
+	approx = count >= threshold
+end
+if approx then
+	redis.call("PFADD", KEYS[3], ARGV[1])
+else
+	redis.call("SADD", KEYS[1], ARGV[1])
+	if sharded then
+		redis.call("INCR", KEYS[2])
+	end
+end
+redis.call("ZADD", KEYS[4], ARGV[6], ARGV[3])
+if KEYS[6] then
+	redis.call("XADD", KEYS[6], "MAXLEN", "~", ARGV[2], "*",
+		"type", "like", "post_id", ARGV[3], "user_id", ARGV[1],
+		"prev", "unliked", "next", "liked")
+end
+return 1
+`)
+
+// unlikeScript is likeScript for removing a like. Likes counted in the
+// HyperLogLog are only removed from the likes of the user.
+var unlikeScript = redis.NewScript(`
+local sharded = ARGV[4] == "1"
+if sharded and redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("SET", KEYS[2], redis.call("SCARD", KEYS[5]))
+end
+local removed = redis.call("SREM", KEYS[1], ARGV[1])
+if removed == 0 and sharded then
+	removed = redis.call("SREM", KEYS[5], ARGV[1])
+end
+if removed == 1 and sharded then
+	redis.call("DECR", KEYS[2])
+end
+local unindexed = redis.call("ZREM", KEYS[4], ARGV[3])
+if removed == 0 and unindexed == 0 then
+	return 0
+end
+if KEYS[6] then
+	redis.call("XADD", KEYS[6], "MAXLEN", "~", ARGV[2], "*",
+		"type", "unlike", "post_id", ARGV[3], "user_id", ARGV[1],
+		"prev", "liked", "next", "unliked")
+end
+return 1
+`)
+
 type PostLikeManager struct {
-	client *redis.Client
+	client    *redis.Client
+	stream    string
+	shards    int
+	threshold int64
 }
 
-func NewPostLikeManager(client *redis.Client) *PostLikeManager {
-	return &PostLikeManager{client}
+// NewPostLikeManager returns a manager that appends every change to stream
+// for downstream services, or no events if stream is empty. The likers of
+// each post are split over shards sets, or kept in one set if shards is 1.
+// Posts with threshold likes switch to approximate counting, unless
+// threshold is 0.
+func NewPostLikeManager(client *redis.Client, stream string, shards int, threshold int64) *PostLikeManager {
+	return &PostLikeManager{client, stream, shards, threshold}
+}
+
+// run runs a like or unlike script for the post.
+func (p *PostLikeManager) run(script *redis.Script, postID, userID string) error {
+	keys := []string{p.setKey(postID, userID), countKey(postID), hllKey(postID), userLikesKey(userID), postID}
+	if p.stream != "" {
+		keys = append(keys, p.stream)
+	}
+	sharded := "0"
+	if p.shards > 1 {
+		sharded = "1"
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return script.Run(p.client, keys, userID, eventStreamMaxLen, postID, sharded, p.threshold, now).Err()
 }
 
 func (p *PostLikeManager) Like(postID, userID string) error {
 	// ? Is Redis Set performant enough to keep track of the unique likes of the user?
-	return p.client.SAdd(postID, userID).Err()
+	return p.run(likeScript, postID, userID)
 }
 
 func (p *PostLikeManager) Unlike(postID, userID string) error {
-	return p.client.SRem(postID, userID).Err()
+	return p.run(unlikeScript, postID, userID)
 }
 
-func (p *PostLikeManager) Count(postID string) int64 {
-	return p.client.SCard(postID).Val()
+// Count returns the number of likes of the post, and whether it is an
+// approximation.
+func (p *PostLikeManager) Count(postID string) (int64, bool) {
+	var count func() (int64, bool, error)
+	p.client.Pipelined(func(pipe redis.Pipeliner) error {
+		count = p.count(pipe, postID)
+		return nil
+	})
+	n, approx, _ := count()
+	return n, approx
+}
+
+// Liked reports whether the user liked the post.
+func (p *PostLikeManager) Liked(postID, userID string) (bool, error) {
+	var liked func() (bool, error)
+	p.client.Pipelined(func(pipe redis.Pipeliner) error {
+		liked = p.liked(pipe, postID, userID)
+		return nil
+	})
+	return liked()
 }
 
 func NewClient() *redis.Client {
@@ -45,12 +175,39 @@ func NewClient() *redis.Client {
 
 func main() {
 	client := NewClient()
-	likeManager := NewPostLikeManager(client)
+	// Viral posts can have millions of likers, split them over 16 sets, and
+	// only count them approximately past 100,000 likes.
+	likeManager := NewPostLikeManager(client, "events:likes", 16, 100000)
 	userID, postID := "john", "hello world"
 	fmt.Println(likeManager.Like(postID, userID))
 	// Same user will be excluded.
 	fmt.Println(likeManager.Like(postID, userID))
 	fmt.Println(likeManager.Count(postID))
+	fmt.Println(likeManager.Liked(postID, userID))
+	fmt.Println(likeManager.LikedAt(postID, userID))
+
+	// The "Your likes" page, newest first.
+	{
+		cursor := ""
+		for {
+			posts, next, err := likeManager.LikedPosts(userID, cursor, 10)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(posts)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+
+	// Render a feed page in one round trip.
+	fmt.Println(likeManager.Summaries([]string{postID, "another post"}, userID))
+
 	fmt.Println(likeManager.Unlike(postID, userID))
 	fmt.Println(likeManager.Count(postID))
+
+	// Only the actual changes were published.
+	fmt.Println(client.XRange("events:likes", "-", "+").Val())
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"hash/crc32"
+	"strconv"
+)
+
+// setKey returns the set holding the like of userID for the post. With
+// sharding, the likers of a post are spread over shards sets by the hash of
+// the user ID, so that no single set becomes a multi-megabyte hot key, and
+// small shards keep the memory-efficient intset or listpack encoding.
+//
+// The number of shards must not change for existing posts, otherwise their
+// likers would be looked up in the wrong shard.
+//
+// Posts liked before sharding was enabled keep their likers in the
+// unsharded set at postID, which is left in place rather than migrated, as
+// spreading it over the shards would need crc32 in Lua. Sharded posts treat
+// it as one more, read-only shard: likers in it are liked, unlikes remove
+// them from it, and new likes only go to the shards. The counter is seeded
+// with its size the first time the post is liked or unliked, and until then
+// the count is its size.
+func (p *PostLikeManager) setKey(postID, userID string) string {
+	if p.shards <= 1 {
+		return postID
+	}
+	shard := crc32.ChecksumIEEE([]byte(userID)) % uint32(p.shards)
+	return postID + ":" + strconv.FormatUint(uint64(shard), 10)
+}
+
+// countKey holds the number of likes of a sharded post, since summing the
+// SCARD of every shard would cost a command per shard.
+func countKey(postID string) string {
+	return postID + ":count"
+}
@@ -0,0 +1,51 @@
+package main
+
+import "github.com/go-redis/redis"
+
+// LikeSummary is what a feed needs to render the likes of a post.
+type LikeSummary struct {
+	PostID string
+	Count  int64
+	// Approximate reports whether Count is an approximation.
+	Approximate bool
+	// Liked reports whether the viewer liked the post.
+	Liked bool
+}
+
+// Summaries returns the like summary of each post as seen by viewerID, in
+// the order of postIDs, in a single round trip.
+func (p *PostLikeManager) Summaries(postIDs []string, viewerID string) ([]LikeSummary, error) {
+	counts := make([]func() (int64, bool, error), len(postIDs))
+	liked := make([]func() (bool, error), len(postIDs))
+	_, err := p.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, postID := range postIDs {
+			counts[i] = p.count(pipe, postID)
+			liked[i] = p.liked(pipe, postID, viewerID)
+		}
+		return nil
+	})
+	// A post without likes is not an error, the commands are checked one
+	// by one below.
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	summaries := make([]LikeSummary, len(postIDs))
+	for i, postID := range postIDs {
+		count, approx, err := counts[i]()
+		if err != nil {
+			return nil, err
+		}
+		viewerLiked, err := liked[i]()
+		if err != nil {
+			return nil, err
+		}
+		summaries[i] = LikeSummary{
+			PostID:      postID,
+			Count:       count,
+			Approximate: approx,
+			Liked:       viewerLiked,
+		}
+	}
+	return summaries, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// LikedPost is a post liked by a user.
+type LikedPost struct {
+	PostID  string
+	LikedAt time.Time
+}
+
+// userLikesKey is the sorted set of posts liked by a user, scored by the
+// time of the like in milliseconds. It is updated in the same script as the
+// likers of the post, so the two never disagree.
+func userLikesKey(userID string) string {
+	return "user:" + userID + ":likes"
+}
+
+// liked queues the commands checking whether the user liked the post on
+// pipe, and returns a function to read the result once the pipeline has run.
+// Likes made before the likes of the user were indexed are only in the set,
+// or in the legacy set of posts liked before sharding.
+func (p *PostLikeManager) liked(pipe redis.Pipeliner, postID, userID string) func() (bool, error) {
+	member := pipe.SIsMember(p.setKey(postID, userID), userID)
+	var legacy *redis.BoolCmd
+	if p.shards > 1 {
+		legacy = pipe.SIsMember(postID, userID)
+	}
+	score := pipe.ZScore(userLikesKey(userID), postID)
+	return func() (bool, error) {
+		if err := member.Err(); err != nil {
+			return false, err
+		}
+		if member.Val() {
+			return true, nil
+		}
+		if legacy != nil {
+			if err := legacy.Err(); err != nil {
+				return false, err
+			}
+			if legacy.Val() {
+				return true, nil
+			}
+		}
+		err := score.Err()
+		if err == redis.Nil {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// LikedAt returns when the user liked the post, and false if the user has
+// not liked it.
+func (p *PostLikeManager) LikedAt(postID, userID string) (time.Time, bool, error) {
+	score, err := p.client.ZScore(userLikesKey(userID), postID).Result()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return fromMillis(score), true, nil
+}
+
+// LikedPosts returns up to limit posts liked by the user, newest first, and
+// the cursor of the next page. See revRangePage for the cursor.
+func (p *PostLikeManager) LikedPosts(userID, cursor string, limit int64) ([]LikedPost, string, error) {
+	zs, next, err := revRangePage(p.client, userLikesKey(userID), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	posts := make([]LikedPost, len(zs))
+	for i, z := range zs {
+		posts[i] = LikedPost{PostID: z.Member.(string), LikedAt: fromMillis(z.Score)}
+	}
+	return posts, next, nil
+}
+
+func fromMillis(ms float64) time.Time {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
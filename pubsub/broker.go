@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Envelope is the JSON published for every message, so that subscribers can
+// tell messages apart without knowing the payload in advance.
+type Envelope struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+
+	// StreamID is set on messages published durably, see durable.go.
+	StreamID string `json:"stream_id,omitempty"`
+
+	// Set on requests and replies, see rpc.go. Deadline is in unix
+	// milliseconds.
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Deadline      int64  `json:"deadline,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Decode unmarshals the payload into v.
+func (e Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Message is an envelope received on a channel. Pattern is set when it was
+// received through a pattern subscription.
+type Message struct {
+	Envelope
+	Channel string
+	Pattern string
+}
+
+// Handler handles a message. Handlers of the same broker run one at a time,
+// in the order the messages were received.
+type Handler func(ctx context.Context, msg Message)
+
+// Broker publishes envelopes and dispatches the ones received to handlers
+// registered by channel or by pattern.
+//
+// Pub/sub is at-most-once: messages published while the connection is down
+// are lost. Run resubscribes after reconnecting and calls OnReconnect, so
+// that handlers can catch up from somewhere else.
+type Broker struct {
+	client *redis.Client
+
+	// pingInterval is how long Run waits for a message before checking
+	// the connection is still alive.
+	pingInterval time.Duration
+
+	// streamMaxLen is the approximate number of messages kept per channel
+	// for PublishDurable.
+	streamMaxLen int64
+
+	// OnReconnect is called when Run resubscribes after losing the
+	// connection, if not nil.
+	OnReconnect func()
+
+	mu       sync.RWMutex
+	sub      *redis.PubSub
+	channels map[string][]Handler
+	patterns map[string][]Handler
+
+	// confirmed holds a channel per subscribed channel or pattern, closed
+	// once Redis has confirmed the subscription.
+	confirmed map[string]chan struct{}
+}
+
+func NewBroker(client *redis.Client) *Broker {
+	return &Broker{
+		client:       client,
+		pingInterval: 30 * time.Second,
+		streamMaxLen: 10000,
+		channels:     make(map[string][]Handler),
+		patterns:     make(map[string][]Handler),
+		confirmed:    make(map[string]chan struct{}),
+	}
+}
+
+// Publish wraps payload in an envelope of the given type and publishes it on
+// channel.
+func (b *Broker) Publish(channel, typ string, payload interface{}) error {
+	env, err := NewEnvelope(typ, payload)
+	if err != nil {
+		return err
+	}
+	_, err = b.publish(channel, env)
+	return err
+}
+
+// publish publishes env on channel and returns the number of subscribers
+// that received it.
+func (b *Broker) publish(channel string, env Envelope) (int64, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return 0, err
+	}
+	return b.client.Publish(channel, data).Result()
+}
+
+// NewEnvelope wraps payload in an envelope with a new ID.
+func NewEnvelope(typ string, payload interface{}) (Envelope, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		Type:      typ,
+		ID:        newID(),
+		Timestamp: time.Now(),
+		Payload:   raw,
+	}, nil
+}
+
+// Handle registers h for messages published on channel. It may be called
+// before or while Run is running.
+func (b *Broker) Handle(channel string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscribed := len(b.channels[channel]) > 0
+	b.channels[channel] = append(b.channels[channel], h)
+	if b.sub == nil || subscribed {
+		return nil
+	}
+	return b.sub.Subscribe(channel)
+}
+
+// HandlePattern registers h for messages published on channels matching the
+// glob-style pattern, e.g. "user:*".
+func (b *Broker) HandlePattern(pattern string, h Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscribed := len(b.patterns[pattern]) > 0
+	b.patterns[pattern] = append(b.patterns[pattern], h)
+	if b.sub == nil || subscribed {
+		return nil
+	}
+	return b.sub.PSubscribe(pattern)
+}
+
+// Run subscribes to the registered channels and patterns, and dispatches the
+// messages received until ctx is done.
+func (b *Broker) Run(ctx context.Context) error {
+	b.mu.Lock()
+	sub := b.client.Subscribe()
+	if err := b.subscribe(sub); err != nil {
+		b.mu.Unlock()
+		sub.Close()
+		return err
+	}
+	b.sub = sub
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.sub = nil
+		b.mu.Unlock()
+		sub.Close()
+	}()
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	// The client reconnects and resubscribes to everything on the next
+	// receive after a failure, and confirms each subscription again.
+	reconnecting := false
+	for {
+		msg, err := sub.ReceiveTimeout(b.pingInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if isTimeout(err) {
+				// Nothing received for a while, make sure the connection
+				// is not silently dead.
+				if err := sub.Ping(); err != nil {
+					reconnecting = true
+				}
+				continue
+			}
+			log.Println("broker: receive failed", err)
+			reconnecting = true
+			time.Sleep(time.Second)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			b.confirm(msg)
+			if reconnecting {
+				reconnecting = false
+				if b.OnReconnect != nil {
+					b.OnReconnect()
+				}
+			}
+		case *redis.Message:
+			b.dispatch(ctx, msg)
+		}
+	}
+}
+
+// Subscribed returns a channel closed once Run has subscribed to channel,
+// after which messages published on it are received. Messages published
+// before that are lost.
+func (b *Broker) Subscribed(channel string) <-chan struct{} {
+	return b.confirmation("subscribe", channel)
+}
+
+// PSubscribed is Subscribed for pattern subscriptions.
+func (b *Broker) PSubscribed(pattern string) <-chan struct{} {
+	return b.confirmation("psubscribe", pattern)
+}
+
+func (b *Broker) confirmation(kind, name string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := kind + ":" + name
+	ch, ok := b.confirmed[key]
+	if !ok {
+		ch = make(chan struct{})
+		b.confirmed[key] = ch
+	}
+	return ch
+}
+
+func (b *Broker) confirm(msg *redis.Subscription) {
+	if msg.Kind != "subscribe" && msg.Kind != "psubscribe" {
+		return
+	}
+	ch := b.confirmation(msg.Kind, msg.Channel)
+	select {
+	case <-ch:
+		// Resubscribed after a reconnect.
+	default:
+		close(ch)
+	}
+}
+
+func (b *Broker) subscribe(sub *redis.PubSub) error {
+	channels := make([]string, 0, len(b.channels))
+	for channel := range b.channels {
+		channels = append(channels, channel)
+	}
+	patterns := make([]string, 0, len(b.patterns))
+	for pattern := range b.patterns {
+		patterns = append(patterns, pattern)
+	}
+	if len(channels) > 0 {
+		if err := sub.Subscribe(channels...); err != nil {
+			return err
+		}
+	}
+	if len(patterns) > 0 {
+		return sub.PSubscribe(patterns...)
+	}
+	return nil
+}
+
+func (b *Broker) dispatch(ctx context.Context, msg *redis.Message) {
+	var env Envelope
+	if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+		log.Println("broker: bad payload", msg.Channel, msg.Payload, err)
+		return
+	}
+	b.mu.RLock()
+	var handlers []Handler
+	if msg.Pattern != "" {
+		handlers = b.patterns[msg.Pattern]
+	} else {
+		handlers = b.channels[msg.Channel]
+	}
+	b.mu.RUnlock()
+	m := Message{Envelope: env, Channel: msg.Channel, Pattern: msg.Pattern}
+	for _, h := range handlers {
+		h(ctx, m)
+	}
+}
+
+func isTimeout(err error) bool {
+	err2, ok := err.(net.Error)
+	return ok && err2.Timeout()
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// publishDurableScript appends the envelope ARGV[2] to the stream KEYS[1],
+// capped at about ARGV[1] entries, and publishes it on the channel KEYS[2]
+// with the ID of the entry, in one step. Subscribers can never see a message
+// live that is not in the stream yet, or the other way round.
+var publishDurableScript = redis.NewScript(`
+local id = redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[1], "*", "envelope", ARGV[2])
+redis.call("PUBLISH", KEYS[2], '{"stream_id":"' .. id .. '",' .. string.sub(ARGV[2], 2))
+return id
+`)
+
+// replayBatchSize is the number of entries read from the stream at a time
+// while catching up.
+const replayBatchSize = 100
+
+// streamKey is the stream keeping the messages published durably on
+// channel.
+func streamKey(channel string) string {
+	return "stream:" + channel
+}
+
+// PublishDurable is Publish for messages that must not be lost by
+// subscribers using SubscribeDurable. The message is also appended to a
+// stream capped at streamMaxLen entries, and its ID in the stream, which is
+// returned, is set as the StreamID of the envelope.
+//
+// Plain subscribers still receive the message as usual.
+func (b *Broker) PublishDurable(channel, typ string, payload interface{}) (string, error) {
+	env, err := NewEnvelope(typ, payload)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	keys := []string{streamKey(channel), channel}
+	res, err := publishDurableScript.Run(b.client, keys, b.streamMaxLen, data).Result()
+	if err != nil {
+		return "", err
+	}
+	id, _ := res.(string)
+	return id, nil
+}
+
+// SubscribeDurable calls h for every message published durably on channel
+// after lastID, in order and without gaps, until ctx is done. h receives
+// messages from the stream until it has caught up, then live ones, and
+// again from the stream after every reconnect. Each message is handled
+// once per call.
+//
+// Callers that need to resume after a restart should persist the StreamID
+// of the last message handled, and pass it as lastID. An empty lastID starts
+// from the messages published from now on.
+//
+// The stream is capped, so a subscriber away for longer than the last
+// streamMaxLen messages misses the ones trimmed in the meantime.
+func (b *Broker) SubscribeDurable(ctx context.Context, channel, lastID string, h Handler) error {
+	stream := streamKey(channel)
+	sub := b.client.Subscribe(channel)
+	defer sub.Close()
+	// Wait for the subscription, so that nothing published after reading
+	// the stream is missed.
+	if _, err := sub.Receive(); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	replay := lastID != ""
+	if lastID == "" {
+		// Everything after the current end of the stream is new.
+		msgs, err := b.client.XRevRangeN(stream, "+", "-", 1).Result()
+		if err != nil {
+			return err
+		}
+		lastID = "0-0"
+		if len(msgs) > 0 {
+			lastID = msgs[0].ID
+		}
+	}
+
+	reconnecting := false
+	for {
+		if replay {
+			var err error
+			lastID, err = b.replay(ctx, channel, lastID, h)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Println("broker: replay failed", channel, err)
+				time.Sleep(time.Second)
+				continue
+			}
+			replay = false
+		}
+
+		msg, err := sub.ReceiveTimeout(b.pingInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if isTimeout(err) {
+				if err := sub.Ping(); err != nil {
+					reconnecting = true
+				}
+				continue
+			}
+			log.Println("broker: receive failed", channel, err)
+			reconnecting = true
+			time.Sleep(time.Second)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// Messages published while disconnected are only in the
+			// stream. Live ones received from now on wait in the
+			// connection until the replay is over.
+			if reconnecting {
+				reconnecting = false
+				replay = true
+			}
+		case *redis.Message:
+			var env Envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				log.Println("broker: bad payload", msg.Channel, msg.Payload, err)
+				continue
+			}
+			if env.StreamID == "" {
+				// Published with Publish, there is nothing to resume from.
+				h(ctx, Message{Envelope: env, Channel: channel})
+				continue
+			}
+			// Already handled during the replay.
+			if compareStreamIDs(env.StreamID, lastID) <= 0 {
+				continue
+			}
+			h(ctx, Message{Envelope: env, Channel: channel})
+			lastID = env.StreamID
+		}
+	}
+}
+
+// replay calls h for the messages in the stream of channel after lastID, and
+// returns the ID of the last one.
+func (b *Broker) replay(ctx context.Context, channel, lastID string, h Handler) (string, error) {
+	stream := streamKey(channel)
+	for {
+		// XRANGE is inclusive, the entry at lastID was handled already.
+		msgs, err := b.client.XRangeN(stream, lastID, "+", replayBatchSize+1).Result()
+		if err != nil {
+			return lastID, err
+		}
+		n := 0
+		for _, msg := range msgs {
+			if msg.ID == lastID {
+				continue
+			}
+			n++
+			data, _ := msg.Values["envelope"].(string)
+			var env Envelope
+			if err := json.Unmarshal([]byte(data), &env); err != nil {
+				log.Println("broker: bad stream entry", stream, msg.ID, err)
+			} else {
+				env.StreamID = msg.ID
+				h(ctx, Message{Envelope: env, Channel: channel})
+			}
+			lastID = msg.ID
+			if ctx.Err() != nil {
+				return lastID, ctx.Err()
+			}
+		}
+		if n < replayBatchSize {
+			return lastID, nil
+		}
+	}
+}
+
+// compareStreamIDs compares two stream IDs of the form "ms-seq", returning
+// -1, 0 or 1.
+func compareStreamIDs(a, b string) int {
+	ams, aseq := parseStreamID(a)
+	bms, bseq := parseStreamID(b)
+	switch {
+	case ams < bms, ams == bms && aseq < bseq:
+		return -1
+	case ams == bms && aseq == bseq:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func parseStreamID(id string) (ms, seq uint64) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		ms, _ = strconv.ParseUint(id, 10, 64)
+		return ms, 0
+	}
+	ms, _ = strconv.ParseUint(id[:i], 10, 64)
+	seq, _ = strconv.ParseUint(id[i+1:], 10, 64)
+	return ms, seq
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// claimExpiredScript returns up to ARGV[3] members of the sorted set KEYS[1]
+// due at ARGV[1], and pushes their score to ARGV[2] so that no other tracker
+// claims them until then.
+var claimExpiredScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, key in ipairs(due) do
+	redis.call("ZADD", KEYS[1], ARGV[2], key)
+end
+return due
+`)
+
+// ackExpiredScript removes ARGV[1] from the sorted set KEYS[1] unless it was
+// given a new expiry since it was claimed at ARGV[2].
+var ackExpiredScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("ZREM", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// ExpiryTracker is a reliable alternative to expired notifications. Expire
+// sets the TTL of the key as usual, and also records its expiry in a sorted
+// set, which Run polls for keys that are due.
+//
+// Each expiry is handled at least once, by a single running tracker at a
+// time, and is not lost when no tracker is running: it is handled when one
+// starts. A key whose handler does not return, e.g. because the process
+// died, is handed out again after lease. Handlers may thus run more than
+// once for the same expiry and should be idempotent.
+type ExpiryTracker struct {
+	client       *redis.Client
+	key          string
+	pollInterval time.Duration
+	lease        time.Duration
+	batchSize    int64
+}
+
+// NewExpiryTracker creates a tracker keeping the expiries in the sorted set
+// key.
+func NewExpiryTracker(client *redis.Client, key string) *ExpiryTracker {
+	return &ExpiryTracker{
+		client:       client,
+		key:          key,
+		pollInterval: 1 * time.Second,
+		lease:        30 * time.Second,
+		batchSize:    100,
+	}
+}
+
+// Expire sets the TTL of key and tracks its expiry. Setting a new TTL
+// replaces the previous one.
+func (t *ExpiryTracker) Expire(key string, ttl time.Duration) error {
+	at := time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+	_, err := t.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.PExpire(key, ttl)
+		pipe.ZAdd(t.key, redis.Z{Score: float64(at), Member: key})
+		return nil
+	})
+	return err
+}
+
+// Forget stops tracking the expiry of key, e.g. after deleting it.
+func (t *ExpiryTracker) Forget(key string) error {
+	return t.client.ZRem(t.key, key).Err()
+}
+
+// Run calls h for every tracked key that is due, until ctx is done. The key
+// may still exist when h is called, if Redis has not expired it yet.
+func (t *ExpiryTracker) Run(ctx context.Context, h KeyEventHandler) {
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := t.poll(ctx, h)
+			if err != nil {
+				log.Println("expiry: poll failed", err)
+			}
+			if err != nil || n < t.batchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *ExpiryTracker) poll(ctx context.Context, h KeyEventHandler) (int64, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	until := now + int64(t.lease/time.Millisecond)
+	res, err := claimExpiredScript.Run(t.client, []string{t.key}, now, until, t.batchSize).Result()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	keys, _ := res.([]interface{})
+	for _, key := range keys {
+		key, _ := key.(string)
+		if ctx.Err() != nil {
+			// The lease runs out and another tracker gets the rest.
+			return 0, nil
+		}
+		h(ctx, KeyEvent{Event: "expired", Key: key})
+		if err := ackExpiredScript.Run(t.client, []string{t.key}, key, until).Err(); err != nil {
+			log.Println("expiry: ack failed", key, err)
+		}
+	}
+	return int64(len(keys)), nil
+}
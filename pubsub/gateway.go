@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gorilla/websocket"
+)
+
+// AuthFunc decides whether the client making r may subscribe to channel. A
+// non-nil error rejects the connection.
+type AuthFunc func(r *http.Request, channel string) error
+
+// Gateway forwards messages published on Redis channels to browsers over
+// WebSocket or Server-Sent Events. Clients pick the channels with the
+// channel query parameter, e.g. /ws?channel=hello&channel=user:1.
+//
+// All clients share a single Redis connection, and each channel is
+// subscribed to once, when its first client arrives, no matter how many
+// clients follow.
+//
+// Every client has a buffer of bufferSize messages. A client too slow to
+// keep up with its channels is disconnected once its buffer is full, rather
+// than holding up the others, and is expected to reconnect.
+type Gateway struct {
+	client    *redis.Client
+	authorize AuthFunc
+	upgrader  websocket.Upgrader
+
+	heartbeat  time.Duration
+	writeWait  time.Duration
+	bufferSize int
+
+	mu       sync.Mutex
+	sub      *redis.PubSub
+	channels map[string]map[*subscriber]struct{}
+}
+
+type gatewayMessage struct {
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// subscriber is a browser connection.
+type subscriber struct {
+	send chan gatewayMessage
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *subscriber) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// NewGateway creates a gateway letting clients subscribe to the channels
+// authorize accepts. A nil authorize accepts every channel.
+func NewGateway(client *redis.Client, authorize AuthFunc) *Gateway {
+	return &Gateway{
+		client:     client,
+		authorize:  authorize,
+		heartbeat:  30 * time.Second,
+		writeWait:  10 * time.Second,
+		bufferSize: 64,
+		sub:        client.Subscribe(),
+		channels:   make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// Run forwards the messages received to the clients until ctx is done, then
+// disconnects them.
+func (g *Gateway) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		g.sub.Close()
+	}()
+	defer func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, clients := range g.channels {
+			for c := range clients {
+				c.close()
+			}
+		}
+	}()
+
+	for {
+		msg, err := g.sub.ReceiveTimeout(g.heartbeat)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if isTimeout(err) {
+				g.sub.Ping()
+				continue
+			}
+			// The next receive reconnects and resubscribes.
+			log.Println("gateway: receive failed", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if msg, ok := msg.(*redis.Message); ok {
+			g.fanout(msg)
+		}
+	}
+}
+
+func (g *Gateway) fanout(msg *redis.Message) {
+	m := gatewayMessage{Channel: msg.Channel, Data: json.RawMessage(msg.Payload)}
+	if !json.Valid(m.Data) {
+		// Not published through the broker, send it as a string.
+		m.Data, _ = json.Marshal(msg.Payload)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for c := range g.channels[msg.Channel] {
+		select {
+		case c.send <- m:
+		default:
+			log.Println("gateway: dropping slow client on", msg.Channel)
+			c.close()
+		}
+	}
+}
+
+func (g *Gateway) subscribe(channels []string) (*subscriber, error) {
+	c := &subscriber{
+		send: make(chan gatewayMessage, g.bufferSize),
+		done: make(chan struct{}),
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var added []string
+	for _, channel := range channels {
+		clients, ok := g.channels[channel]
+		if !ok {
+			clients = make(map[*subscriber]struct{})
+			g.channels[channel] = clients
+			added = append(added, channel)
+		}
+		clients[c] = struct{}{}
+	}
+	if len(added) == 0 {
+		return c, nil
+	}
+	if err := g.sub.Subscribe(added...); err != nil {
+		g.remove(channels, c)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (g *Gateway) unsubscribe(channels []string, c *subscriber) {
+	c.close()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if removed := g.remove(channels, c); len(removed) > 0 {
+		if err := g.sub.Unsubscribe(removed...); err != nil {
+			log.Println("gateway: unsubscribe failed", removed, err)
+		}
+	}
+}
+
+// remove removes c from channels and returns the channels left without
+// clients.
+func (g *Gateway) remove(channels []string, c *subscriber) []string {
+	var removed []string
+	for _, channel := range channels {
+		clients := g.channels[channel]
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(g.channels, channel)
+			removed = append(removed, channel)
+		}
+	}
+	return removed
+}
+
+// channelsOf returns the channels requested by r, or writes an error
+// response if there are none or any of them is not authorized.
+func (g *Gateway) channelsOf(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	channels := r.URL.Query()["channel"]
+	if len(channels) == 0 {
+		http.Error(w, "missing channel", http.StatusBadRequest)
+		return nil, false
+	}
+	if g.authorize == nil {
+		return channels, true
+	}
+	for _, channel := range channels {
+		if err := g.authorize(r, channel); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil, false
+		}
+	}
+	return channels, true
+}
+
+// ServeWebSocket sends each message as a JSON text frame with the channel
+// and the data, and pings the client every heartbeat. Clients that do not
+// answer the pings are disconnected.
+func (g *Gateway) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	channels, ok := g.channelsOf(w, r)
+	if !ok {
+		return
+	}
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has replied to the client already.
+		return
+	}
+	defer conn.Close()
+
+	c, err := g.subscribe(channels)
+	if err != nil {
+		log.Println("gateway: subscribe failed", channels, err)
+		return
+	}
+	defer g.unsubscribe(channels, c)
+
+	// Clients do not send anything but control frames, read them to process
+	// the pongs and notice when the client goes away.
+	conn.SetReadDeadline(time.Now().Add(2 * g.heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * g.heartbeat))
+	})
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				c.close()
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(g.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(g.writeWait))
+			return
+		case msg := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(g.writeWait))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(g.writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeSSE sends each message as an event named after its channel, and a
+// comment every heartbeat to keep proxies from closing the connection.
+func (g *Gateway) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	channels, ok := g.channelsOf(w, r)
+	if !ok {
+		return
+	}
+	c, err := g.subscribe(channels)
+	if err != nil {
+		log.Println("gateway: subscribe failed", channels, err)
+		http.Error(w, "subscribe failed", http.StatusServiceUnavailable)
+		return
+	}
+	defer g.unsubscribe(channels, c)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(g.heartbeat)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			rc.SetWriteDeadline(time.Now().Add(g.writeWait))
+			err = writeEvent(w, msg)
+		case <-ticker.C:
+			rc.SetWriteDeadline(time.Now().Add(g.writeWait))
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, msg gatewayMessage) error {
+	if _, err := fmt.Fprintf(w, "event: %s\n", msg.Channel); err != nil {
+		return err
+	}
+	// Compact JSON has no newlines, but be safe: each line of the data
+	// needs its own field.
+	for _, line := range strings.Split(string(msg.Data), "\n") {
+		if _, err := fmt.Fprintf(w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w, "\n")
+	return err
+}
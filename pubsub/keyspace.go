@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// eventFlags maps the key events that can be handled to the
+// notify-keyspace-events flag enabling them.
+var eventFlags = map[string]string{
+	"expired": "x",
+	"evicted": "e",
+	"del":     "g",
+	"expire":  "g",
+	"set":     "$",
+}
+
+// KeyEvent is a key event notification, e.g. the expiry of a key.
+type KeyEvent struct {
+	Event string
+	Key   string
+}
+
+type KeyEventHandler func(ctx context.Context, ev KeyEvent)
+
+type prefixHandler struct {
+	event  string
+	prefix string
+	h      KeyEventHandler
+}
+
+// KeyspaceListener calls handlers registered by key prefix when keys of the
+// database of the client expire, are evicted, etc.
+//
+// Notifications are pub/sub messages, so they are delivered at most once:
+//   - Events happening while the listener is disconnected or not running are
+//     lost, and nothing tells it which ones.
+//   - Every running listener receives every event, there is no way to share
+//     the work between instances.
+//   - Expired events are sent when Redis deletes the key, which is when it
+//     is accessed or found by the background expiry cycle, and can be well
+//     after its TTL ran out.
+//   - Handlers only get the key, which no longer exists. Keep what they need
+//     somewhere else, e.g. a shadow key expiring later.
+//
+// Use ExpiryTracker for keys whose expiry must be handled.
+type KeyspaceListener struct {
+	client       *redis.Client
+	db           int
+	pingInterval time.Duration
+
+	mu       sync.RWMutex
+	handlers []prefixHandler
+}
+
+func NewKeyspaceListener(client *redis.Client) *KeyspaceListener {
+	return &KeyspaceListener{
+		client:       client,
+		db:           client.Options().DB,
+		pingInterval: 30 * time.Second,
+	}
+}
+
+// Handle registers h for event on keys starting with prefix. It must be
+// called before Run.
+func (l *KeyspaceListener) Handle(event, prefix string, h KeyEventHandler) error {
+	if _, ok := eventFlags[event]; !ok {
+		return fmt.Errorf("keyspace: unsupported event %q", event)
+	}
+	l.mu.Lock()
+	l.handlers = append(l.handlers, prefixHandler{event, prefix, h})
+	l.mu.Unlock()
+	return nil
+}
+
+// Configure enables the key event notifications needed by the registered
+// handlers, keeping the ones already enabled. Managed Redis services often
+// disable CONFIG, in which case notify-keyspace-events has to be set up
+// there instead.
+func (l *KeyspaceListener) Configure() error {
+	res, err := l.client.ConfigGet("notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	current := ""
+	if len(res) == 2 {
+		current, _ = res[1].(string)
+	}
+	flags := current
+	add := func(flag string) {
+		if !strings.Contains(flags, flag) {
+			flags += flag
+		}
+	}
+	add("E")
+	l.mu.RLock()
+	for _, h := range l.handlers {
+		// A is an alias for all the event flags but m and n.
+		if !strings.Contains(flags, "A") {
+			add(eventFlags[h.event])
+		}
+	}
+	l.mu.RUnlock()
+	if flags == current {
+		return nil
+	}
+	return l.client.ConfigSet("notify-keyspace-events", flags).Err()
+}
+
+// channel is the key event channel of event in the database of the client.
+func (l *KeyspaceListener) channel(event string) string {
+	return fmt.Sprintf("__keyevent@%d__:%s", l.db, event)
+}
+
+// Run calls the handlers for the events received until ctx is done.
+func (l *KeyspaceListener) Run(ctx context.Context) error {
+	l.mu.RLock()
+	seen := make(map[string]bool)
+	var channels []string
+	for _, h := range l.handlers {
+		if !seen[h.event] {
+			seen[h.event] = true
+			channels = append(channels, l.channel(h.event))
+		}
+	}
+	l.mu.RUnlock()
+	if len(channels) == 0 {
+		return nil
+	}
+
+	sub := l.client.Subscribe(channels...)
+	defer sub.Close()
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	for {
+		msg, err := sub.ReceiveTimeout(l.pingInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if isTimeout(err) {
+				sub.Ping()
+				continue
+			}
+			// The next receive reconnects and resubscribes, events in
+			// between are lost.
+			log.Println("keyspace: receive failed", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if msg, ok := msg.(*redis.Message); ok {
+			event := msg.Channel[strings.LastIndexByte(msg.Channel, ':')+1:]
+			l.dispatch(ctx, KeyEvent{Event: event, Key: msg.Payload})
+		}
+	}
+}
+
+func (l *KeyspaceListener) dispatch(ctx context.Context, ev KeyEvent) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, h := range l.handlers {
+		if h.event == ev.Event && strings.HasPrefix(ev.Key, h.prefix) {
+			h.h(ctx, ev)
+		}
+	}
+}
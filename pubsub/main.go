@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func NewClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		DB:       0,
+		Password: "",
+		Addr:     "localhost:6379",
+	})
+}
+
+type Greeting struct {
+	Text string `json:"text"`
+}
+
+type Sum struct {
+	A, B int
+}
+
+// Usage:
+//
+//	go run . subscribe
+//	go run . publish
+//	go run . serve
+//	go run . call
+//	go run . gateway
+//	go run . durable-subscribe [last-id]
+//	go run . durable-publish
+//	go run . keyspace
+func main() {
+	client := NewClient()
+	defer client.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	broker := NewBroker(client)
+	cmd := "subscribe"
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+	switch cmd {
+	case "publish":
+		if err := broker.Publish("hello", "greeting", Greeting{Text: "world"}); err != nil {
+			log.Fatal(err)
+		}
+		if err := broker.Publish("user:1", "user.updated", map[string]interface{}{"id": 1, "at": time.Now()}); err != nil {
+			log.Fatal(err)
+		}
+	case "subscribe":
+		broker.Handle("hello", func(ctx context.Context, msg Message) {
+			var g Greeting
+			if err := msg.Decode(&g); err != nil {
+				log.Println(err)
+				return
+			}
+			fmt.Println(msg.Channel, msg.Type, msg.ID, g.Text)
+		})
+		broker.HandlePattern("user:*", func(ctx context.Context, msg Message) {
+			fmt.Println(msg.Pattern, msg.Channel, msg.Type, string(msg.Payload))
+		})
+		broker.OnReconnect = func() {
+			log.Println("reconnected, messages may have been missed")
+		}
+		if err := broker.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+	case "serve":
+		server := NewRPCServer(broker, 10)
+		server.Serve("rpc:sum", func(ctx context.Context, req Envelope) (interface{}, error) {
+			var sum Sum
+			if err := req.Decode(&sum); err != nil {
+				return nil, err
+			}
+			return sum.A + sum.B, nil
+		})
+		if err := broker.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+		server.Wait()
+	case "call":
+		rpc, err := NewRPCClient(broker, 5*time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go broker.Run(ctx)
+
+		reply, err := rpc.Call(ctx, "rpc:sum", Sum{A: 1, B: 2})
+		if err != nil {
+			log.Fatal(err)
+		}
+		var res int
+		if err := reply.Decode(&res); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("1 + 2 =", res)
+	case "durable-publish":
+		id, err := broker.PublishDurable("orders", "order.created", map[string]interface{}{"id": 1})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("published", id)
+	case "durable-subscribe":
+		// Resume from the ID printed by a previous run, if any.
+		lastID := ""
+		if len(os.Args) > 2 {
+			lastID = os.Args[2]
+		}
+		err := broker.SubscribeDurable(ctx, "orders", lastID, func(ctx context.Context, msg Message) {
+			fmt.Println(msg.StreamID, msg.Type, string(msg.Payload))
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "keyspace":
+		listener := NewKeyspaceListener(client)
+		listener.Handle("expired", "session:", func(ctx context.Context, ev KeyEvent) {
+			fmt.Println("session ended", ev.Key)
+		})
+		listener.Handle("evicted", "cart:", func(ctx context.Context, ev KeyEvent) {
+			fmt.Println("cart evicted", ev.Key)
+		})
+		if err := listener.Configure(); err != nil {
+			log.Println("configure notifications:", err)
+		}
+		go listener.Run(ctx)
+
+		// Delayed tasks must not be missed, track them in a sorted set.
+		tracker := NewExpiryTracker(client, "expiry:tasks")
+		go tracker.Run(ctx, func(ctx context.Context, ev KeyEvent) {
+			fmt.Println("task due", ev.Key)
+		})
+
+		client.Set("session:1", "john", 2*time.Second)
+		client.Set("task:1", "send reminder", 0)
+		if err := tracker.Expire("task:1", 3*time.Second); err != nil {
+			log.Fatal(err)
+		}
+		<-ctx.Done()
+	case "gateway":
+		// Browsers connect to ws://localhost:8080/ws?channel=hello&token=...
+		// or http://localhost:8080/events?channel=hello&token=...
+		gateway := NewGateway(client, func(r *http.Request, channel string) error {
+			if r.URL.Query().Get("token") == "" {
+				return errors.New("missing token")
+			}
+			if strings.HasPrefix(channel, "rpc:") {
+				return fmt.Errorf("channel %q is private", channel)
+			}
+			return nil
+		})
+		go gateway.Run(ctx)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ws", gateway.ServeWebSocket)
+		mux.HandleFunc("/events", gateway.ServeSSE)
+		server := &http.Server{Addr: ":8080", Handler: mux}
+		go func() {
+			<-ctx.Done()
+			server.Shutdown(context.Background())
+		}()
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown command %q", cmd)
+	}
+}
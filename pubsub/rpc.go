@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrNoResponders = errors.New("rpc: no responders")
+
+// Envelope types of requests and replies.
+const (
+	RequestType = "rpc.request"
+	ReplyType   = "rpc.reply"
+)
+
+// RemoteError is an error returned by the handler of a request.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc: " + e.Message
+}
+
+// RPCClient sends requests and waits for their replies on a reply channel of
+// its own. The broker must be running for replies to be received, calls wait
+// until it has subscribed to the reply channel.
+type RPCClient struct {
+	broker       *Broker
+	replyChannel string
+	timeout      time.Duration
+
+	mu      sync.Mutex
+	pending map[string]chan Envelope
+}
+
+// NewRPCClient creates a client whose calls time out after timeout, unless
+// the context passed to Call expires earlier.
+func NewRPCClient(broker *Broker, timeout time.Duration) (*RPCClient, error) {
+	c := &RPCClient{
+		broker:       broker,
+		replyChannel: "rpc:reply:" + newID(),
+		timeout:      timeout,
+		pending:      make(map[string]chan Envelope),
+	}
+	if err := broker.Handle(c.replyChannel, c.reply); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Call publishes req on channel and returns the reply. Every responder
+// subscribed to channel receives the request, and the first reply wins.
+func (c *RPCClient) Call(ctx context.Context, channel string, req interface{}) (Envelope, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// A reply sent before the subscription would be lost.
+	select {
+	case <-ctx.Done():
+		return Envelope{}, ctx.Err()
+	case <-c.broker.Subscribed(c.replyChannel):
+	}
+
+	env, err := NewEnvelope(RequestType, req)
+	if err != nil {
+		return Envelope{}, err
+	}
+	env.ReplyTo = c.replyChannel
+	if deadline, ok := ctx.Deadline(); ok {
+		env.Deadline = deadline.UnixNano() / int64(time.Millisecond)
+	}
+
+	// Register before publishing, the reply may arrive before Publish
+	// returns.
+	ch := make(chan Envelope, 1)
+	c.mu.Lock()
+	c.pending[env.ID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, env.ID)
+		c.mu.Unlock()
+	}()
+
+	n, err := c.broker.publish(channel, env)
+	if err != nil {
+		return Envelope{}, err
+	}
+	if n == 0 {
+		return Envelope{}, ErrNoResponders
+	}
+
+	select {
+	case <-ctx.Done():
+		return Envelope{}, ctx.Err()
+	case reply := <-ch:
+		if reply.Error != "" {
+			return reply, &RemoteError{Message: reply.Error}
+		}
+		return reply, nil
+	}
+}
+
+// reply hands a reply to the call waiting for it. Replies after the first,
+// or to calls that timed out, are dropped.
+func (c *RPCClient) reply(ctx context.Context, msg Message) {
+	c.mu.Lock()
+	ch, ok := c.pending[msg.CorrelationID]
+	delete(c.pending, msg.CorrelationID)
+	c.mu.Unlock()
+	if ok {
+		ch <- msg.Envelope
+	}
+}
+
+// RPCHandler returns the reply to a request, or an error which is sent back
+// to the caller as a RemoteError.
+type RPCHandler func(ctx context.Context, req Envelope) (interface{}, error)
+
+// RPCServer runs the handlers of requests received on a channel, at most
+// concurrency at a time.
+//
+// When all slots are busy, the server stops reading messages until one is
+// free, which holds up every other handler of the broker. Give the server a
+// broker of its own if that matters.
+type RPCServer struct {
+	broker *Broker
+	sem    chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewRPCServer(broker *Broker, concurrency int) *RPCServer {
+	return &RPCServer{
+		broker: broker,
+		sem:    make(chan struct{}, concurrency),
+	}
+}
+
+// Serve registers h for the requests received on channel.
+func (s *RPCServer) Serve(channel string, h RPCHandler) error {
+	return s.broker.Handle(channel, func(ctx context.Context, msg Message) {
+		if msg.ReplyTo == "" {
+			log.Println("rpc: request without reply channel", msg.Channel, msg.ID)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case s.sem <- struct{}{}:
+		}
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				<-s.sem
+				s.wg.Done()
+			}()
+			s.handle(ctx, h, msg.Envelope)
+		}()
+	})
+}
+
+// Wait waits for the requests being handled to finish.
+func (s *RPCServer) Wait() {
+	s.wg.Wait()
+}
+
+func (s *RPCServer) handle(ctx context.Context, h RPCHandler, req Envelope) {
+	if req.Deadline > 0 {
+		deadline := time.Unix(0, req.Deadline*int64(time.Millisecond))
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	// The caller has given up already, do not bother.
+	if ctx.Err() != nil {
+		return
+	}
+	res, err := h(ctx, req)
+
+	reply, encErr := NewEnvelope(ReplyType, res)
+	if encErr != nil {
+		err = encErr
+	}
+	reply.CorrelationID = req.ID
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	if _, err := s.broker.publish(req.ReplyTo, reply); err != nil {
+		log.Println("rpc: reply failed", req.ReplyTo, req.ID, err)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"time"
 
 	"github.com/go-redis/redis"
 )
@@ -11,35 +15,133 @@ import (
 // Users can only react once for a post.
 // Users can unreact to a post.
 // System can return the total number of reactions of a post.
-// System can return the total number of count for each reactions. (?)
+// System can return the total number of count for each reactions.
+// System can return what a user reacted to a post.
+// System can list who reacted to a post, newest first, by reaction.
 
 type ReactionManager interface {
 	React(mediaID, userID, reactionType string) error
 	Unreact(mediaID, userID string) error
 	Count(mediaID string) int64
+	Counts(mediaID string) map[string]int64
+	UserReaction(mediaID, userID string) (string, error)
+	Reactors(mediaID, reactionType, cursor string, limit int64) ([]Reactor, string, error)
 }
 
+// reactScript sets the reaction of the user and keeps the counter hash and
+// the reactor indexes in sync. Changing from one reaction to another moves
+// the count and the index entry from the old reaction to the new one.
+//
+// KEYS[3] is the sorted set of all reactors, and KEYS[3]:<reaction> the one
+// of each reaction. The per-reaction keys are built in the script, so this
+// only works on a single node. If KEYS[4] is given, the change is appended
+// to that stream in the same atomic step.
+var reactScript = redis.NewScript(`
+local prev = redis.call("HGET", KEYS[1], ARGV[1])
+if prev == ARGV[2] then
+	return 0
+end
+if KEYS[4] then
+	redis.call("XADD", KEYS[4], "MAXLEN", "~", ARGV[4], "*",
+		"type", "react", "post_id", KEYS[1], "user_id", ARGV[1],
+		"prev", prev or "", "next", ARGV[2])
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+if prev then
+	if redis.call("HINCRBY", KEYS[2], prev, -1) <= 0 then
+		redis.call("HDEL", KEYS[2], prev)
+	end
+	redis.call("ZREM", KEYS[3] .. ":" .. prev, ARGV[1])
+end
+redis.call("HINCRBY", KEYS[2], ARGV[2], 1)
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+redis.call("ZADD", KEYS[3] .. ":" .. ARGV[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+// unreactScript removes the reaction of the user, decrements its count and
+// removes the user from the reactor indexes.
+var unreactScript = redis.NewScript(`
+local prev = redis.call("HGET", KEYS[1], ARGV[1])
+if not prev then
+	return 0
+end
+if KEYS[4] then
+	redis.call("XADD", KEYS[4], "MAXLEN", "~", ARGV[2], "*",
+		"type", "unreact", "post_id", KEYS[1], "user_id", ARGV[1],
+		"prev", prev, "next", "")
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+if redis.call("HINCRBY", KEYS[2], prev, -1) <= 0 then
+	redis.call("HDEL", KEYS[2], prev)
+end
+redis.call("ZREM", KEYS[3], ARGV[1])
+redis.call("ZREM", KEYS[3] .. ":" .. prev, ARGV[1])
+return 1
+`)
+
+// eventStreamMaxLen is the approximate length reactScript and unreactScript
+// trim the reaction stream to on every event.
+const eventStreamMaxLen = 100000
+
 type PostReactionManager struct {
-	client *redis.Client
+	client   *redis.Client
+	registry *ReactionRegistry
+	scope    string
+	stream   string
+}
+
+// NewPostReactionManager returns a manager accepting the reactions allowed
+// in scope by registry. Any reaction is accepted if registry is nil. Every
+// change is appended to stream for downstream services, unless it is empty.
+func NewPostReactionManager(client *redis.Client, registry *ReactionRegistry, scope, stream string) *PostReactionManager {
+	return &PostReactionManager{client, registry, scope, stream}
+}
+
+func (p *PostReactionManager) keys(postID string) []string {
+	keys := []string{postID, countKey(postID), reactorsKey(postID, "")}
+	if p.stream != "" {
+		keys = append(keys, p.stream)
+	}
+	return keys
 }
 
-func NewPostReactionManager(client *redis.Client) *PostReactionManager {
-	return &PostReactionManager{client}
+// countKey is the hash of reaction type to number of reactions of a post.
+func countKey(postID string) string {
+	return postID + ":count"
 }
 
 func (p *PostReactionManager) React(postID, userID, reactionType string) error {
+	if p.registry != nil {
+		if err := p.registry.Validate(p.scope, reactionType); err != nil {
+			return err
+		}
+	}
 	// ? Is Redis Set performant enough to keep track of the unique likes of the user?
-	return p.client.HSet(postID, userID, reactionType).Err()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return reactScript.Run(p.client, p.keys(postID), userID, reactionType, now, eventStreamMaxLen).Err()
 }
 
 func (p *PostReactionManager) Unreact(postID, userID string) error {
-	return p.client.HDel(postID, userID).Err()
+	return unreactScript.Run(p.client, p.keys(postID), userID, eventStreamMaxLen).Err()
 }
 
 func (p *PostReactionManager) Count(postID string) int64 {
 	return p.client.HLen(postID).Val()
 }
 
+func (p *PostReactionManager) Counts(postID string) map[string]int64 {
+	counts := make(map[string]int64)
+	for reactionType, count := range p.client.HGetAll(countKey(postID)).Val() {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			continue
+		}
+		counts[reactionType] = n
+	}
+	return counts
+}
+
 func NewClient() *redis.Client {
 	return redis.NewClient(&redis.Options{
 		Addr:     "localhost:6379",
@@ -50,20 +152,65 @@ func NewClient() *redis.Client {
 
 func main() {
 	client := NewClient()
-	reactionManager := NewPostReactionManager(client)
+	registry := NewReactionRegistry(client, 1*time.Minute)
+	scope := "post"
+	for _, reaction := range []string{"happy", "sad", "angry", "amazed", "like"} {
+		if err := registry.Add(scope, reaction); err != nil {
+			log.Fatal(err)
+		}
+	}
+	reactionManager := NewPostReactionManager(client, registry, scope, "events:reactions")
 
 	userID := "john"
 	postID := "hello world"
 	reactionType := "happy" // sad, angry, amazed, like
 
+	{
+		// Typos are rejected.
+		err := reactionManager.React(postID, userID, "hapy")
+		fmt.Println(err, errors.Is(err, ErrUnknownReaction))
+
+		// Retired reactions can no longer be used, but their counts are
+		// kept.
+		fmt.Println(registry.Retire(scope, "amazed"))
+		err = reactionManager.React(postID, userID, "amazed")
+		fmt.Println(err, errors.Is(err, ErrRetiredReaction))
+	}
+
 	{
 		fmt.Println(reactionManager.React(postID, userID, reactionType))
 		// Second time react with sad emoji.
 		fmt.Println(reactionManager.React(postID, userID, "sad"))
 	}
 
-	// We can only get the total count of the posts.
+	// The total count of the post, and the count for each reaction.
 	fmt.Println(reactionManager.Count(postID))
+	fmt.Println(reactionManager.Counts(postID))
+
+	// Render a feed page in one round trip.
+	fmt.Println(reactionManager.Summaries([]string{postID, "another post"}, userID))
+
+	// What did john react, and who reacted with sad?
+	fmt.Println(reactionManager.UserReaction(postID, userID))
+	{
+		cursor := ""
+		for {
+			reactors, next, err := reactionManager.Reactors(postID, "sad", cursor, 10)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(reactors)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+
 	fmt.Println(reactionManager.Unreact(postID, userID))
 	fmt.Println(reactionManager.Count(postID))
+	fmt.Println(reactionManager.Counts(postID))
+
+	// Each change was published with the previous and new reaction.
+	fmt.Println(client.XRange("events:reactions", "-", "+").Val())
 }
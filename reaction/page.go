@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+// revRangePage returns up to limit members of the sorted set key, highest
+// score first, and the cursor of the next page. Pass an empty cursor for the
+// first page. The returned cursor is empty after the last page.
+//
+// The cursor is the score and member of the last member returned rather
+// than an offset, so that members added in the meantime do not shift the
+// pages.
+func revRangePage(client *redis.Client, key, cursor string, limit int64) ([]redis.Z, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid limit %d", limit)
+	}
+	max := "+inf"
+	var after *redis.Z
+	if cursor != "" {
+		z, err := parseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &z
+		max = strconv.FormatFloat(z.Score, 'f', -1, 64)
+	}
+
+	// Fetch one more than asked for to know if there is a next page.
+	want := limit + 1
+	var zs []redis.Z
+	for offset := int64(0); int64(len(zs)) < want; {
+		batch, err := client.ZRevRangeByScoreWithScores(key, redis.ZRangeBy{
+			Min:    "-inf",
+			Max:    max,
+			Offset: offset,
+			Count:  want,
+		}).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, z := range batch {
+			// Members with the score of the cursor come in reverse member
+			// order, skip the ones up to the cursor.
+			if after == nil || z.Score != after.Score || z.Member.(string) < after.Member.(string) {
+				zs = append(zs, z)
+			}
+		}
+		if int64(len(batch)) < want {
+			break
+		}
+		offset += int64(len(batch))
+	}
+	if int64(len(zs)) <= limit {
+		return zs, "", nil
+	}
+	zs = zs[:limit]
+	last := zs[limit-1]
+	return zs, fmt.Sprintf("%s:%s", strconv.FormatFloat(last.Score, 'f', -1, 64), last.Member), nil
+}
+
+func parseCursor(cursor string) (redis.Z, error) {
+	i := strings.IndexByte(cursor, ':')
+	if i < 0 {
+		return redis.Z{}, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	score, err := strconv.ParseFloat(cursor[:i], 64)
+	if err != nil {
+		return redis.Z{}, fmt.Errorf("invalid cursor %q: %v", cursor, err)
+	}
+	return redis.Z{Score: score, Member: cursor[i+1:]}, nil
+}
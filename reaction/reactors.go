@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Reactor is a user who reacted to a post.
+type Reactor struct {
+	UserID    string
+	Reaction  string
+	ReactedAt time.Time
+}
+
+// reactorsKey is the sorted set of users who reacted to a post with
+// reactionType, or with any reaction if reactionType is empty, scored by the
+// time of the reaction in milliseconds.
+func reactorsKey(postID, reactionType string) string {
+	if reactionType == "" {
+		return postID + ":reactors"
+	}
+	return postID + ":reactors:" + reactionType
+}
+
+// UserReaction returns the reaction of the user to the post, or an empty
+// string if the user has not reacted.
+func (p *PostReactionManager) UserReaction(postID, userID string) (string, error) {
+	reaction, err := p.client.HGet(postID, userID).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return reaction, err
+}
+
+// Reactors returns up to limit users who reacted to the post with
+// reactionType, or with any reaction if it is empty, newest first, and the
+// cursor of the next page. See revRangePage for the cursor.
+func (p *PostReactionManager) Reactors(postID, reactionType, cursor string, limit int64) ([]Reactor, string, error) {
+	zs, next, err := revRangePage(p.client, reactorsKey(postID, reactionType), cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reactors := make([]Reactor, len(zs))
+	reactions := make([]*redis.StringCmd, len(zs))
+	_, err = p.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, z := range zs {
+			reactions[i] = pipe.HGet(postID, z.Member.(string))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, "", err
+	}
+	for i, z := range zs {
+		ms := int64(z.Score)
+		reactors[i] = Reactor{
+			UserID:    z.Member.(string),
+			Reaction:  reactions[i].Val(),
+			ReactedAt: time.Unix(0, ms*int64(time.Millisecond)),
+		}
+	}
+	return reactors, next, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	reactionActive  = "active"
+	reactionRetired = "retired"
+)
+
+var (
+	ErrUnknownReaction = errors.New("unknown reaction")
+	ErrRetiredReaction = errors.New("retired reaction")
+)
+
+// ReactionError is returned when a reaction is not allowed in a scope. Use
+// errors.Is with ErrUnknownReaction or ErrRetiredReaction to tell why.
+type ReactionError struct {
+	Scope    string
+	Reaction string
+	Err      error
+}
+
+func (e *ReactionError) Error() string {
+	return fmt.Sprintf("reaction %q in %q: %v", e.Reaction, e.Scope, e.Err)
+}
+
+func (e *ReactionError) Unwrap() error {
+	return e.Err
+}
+
+// ReactionRegistry holds the reactions allowed in each scope, e.g. a tenant
+// or a post type. Each scope is a hash of reaction to its status in Redis,
+// cached locally for ttl. Retired reactions stay in the hash so that they
+// can no longer be used, while the existing reactions and counts for them
+// are kept.
+type ReactionRegistry struct {
+	client *redis.Client
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]vocabulary
+}
+
+type vocabulary struct {
+	statuses map[string]string
+	expiry   time.Time
+}
+
+func NewReactionRegistry(client *redis.Client, ttl time.Duration) *ReactionRegistry {
+	return &ReactionRegistry{
+		client: client,
+		ttl:    ttl,
+		cache:  make(map[string]vocabulary),
+	}
+}
+
+func vocabularyKey(scope string) string {
+	return "reactions:vocabulary:" + scope
+}
+
+// Add allows reaction in scope, restoring it if it was retired.
+func (r *ReactionRegistry) Add(scope, reaction string) error {
+	if err := r.client.HSet(vocabularyKey(scope), reaction, reactionActive).Err(); err != nil {
+		return err
+	}
+	r.forget(scope)
+	return nil
+}
+
+// Retire stops reaction from being used in scope.
+func (r *ReactionRegistry) Retire(scope, reaction string) error {
+	ok, err := r.client.HExists(vocabularyKey(scope), reaction).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ReactionError{scope, reaction, ErrUnknownReaction}
+	}
+	if err := r.client.HSet(vocabularyKey(scope), reaction, reactionRetired).Err(); err != nil {
+		return err
+	}
+	r.forget(scope)
+	return nil
+}
+
+// Reactions returns the reactions that can currently be used in scope.
+func (r *ReactionRegistry) Reactions(scope string) ([]string, error) {
+	statuses, err := r.statuses(scope)
+	if err != nil {
+		return nil, err
+	}
+	var reactions []string
+	for reaction, status := range statuses {
+		if status == reactionActive {
+			reactions = append(reactions, reaction)
+		}
+	}
+	return reactions, nil
+}
+
+// Validate returns a *ReactionError if reaction cannot be used in scope.
+func (r *ReactionRegistry) Validate(scope, reaction string) error {
+	statuses, err := r.statuses(scope)
+	if err != nil {
+		return err
+	}
+	switch statuses[reaction] {
+	case reactionActive:
+		return nil
+	case reactionRetired:
+		return &ReactionError{scope, reaction, ErrRetiredReaction}
+	default:
+		return &ReactionError{scope, reaction, ErrUnknownReaction}
+	}
+}
+
+// statuses returns the reactions of scope, from the local cache if it has
+// not expired. Other instances pick up changes once their copy expires.
+func (r *ReactionRegistry) statuses(scope string) (map[string]string, error) {
+	r.mu.Lock()
+	v, ok := r.cache[scope]
+	r.mu.Unlock()
+	if ok && time.Now().Before(v.expiry) {
+		return v.statuses, nil
+	}
+
+	statuses, err := r.client.HGetAll(vocabularyKey(scope)).Result()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cache[scope] = vocabulary{statuses, time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+	return statuses, nil
+}
+
+func (r *ReactionRegistry) forget(scope string) {
+	r.mu.Lock()
+	delete(r.cache, scope)
+	r.mu.Unlock()
+}
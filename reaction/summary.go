@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/go-redis/redis"
+)
+
+// ReactionSummary is what a feed needs to render the reactions of a post.
+type ReactionSummary struct {
+	PostID string
+	Count  int64
+	Counts map[string]int64
+	// ViewerReaction is the reaction of the viewer, or empty if the viewer
+	// has not reacted.
+	ViewerReaction string
+}
+
+// Summaries returns the reaction summary of each post as seen by viewerID,
+// in the order of postIDs, in a single round trip.
+func (p *PostReactionManager) Summaries(postIDs []string, viewerID string) ([]ReactionSummary, error) {
+	counts := make([]*redis.IntCmd, len(postIDs))
+	perType := make([]*redis.StringStringMapCmd, len(postIDs))
+	viewer := make([]*redis.StringCmd, len(postIDs))
+	_, err := p.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, postID := range postIDs {
+			counts[i] = pipe.HLen(postID)
+			perType[i] = pipe.HGetAll(countKey(postID))
+			viewer[i] = pipe.HGet(postID, viewerID)
+		}
+		return nil
+	})
+	// A viewer who has not reacted to a post is not an error, the commands
+	// are checked one by one below.
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	summaries := make([]ReactionSummary, len(postIDs))
+	for i, postID := range postIDs {
+		if err := counts[i].Err(); err != nil {
+			return nil, err
+		}
+		if err := perType[i].Err(); err != nil {
+			return nil, err
+		}
+		if err := viewer[i].Err(); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		s := ReactionSummary{
+			PostID:         postID,
+			Count:          counts[i].Val(),
+			Counts:         make(map[string]int64),
+			ViewerReaction: viewer[i].Val(),
+		}
+		for reactionType, count := range perType[i].Val() {
+			n, err := strconv.ParseInt(count, 10, 64)
+			if err != nil {
+				continue
+			}
+			s.Counts[reactionType] = n
+		}
+		summaries[i] = s
+	}
+	return summaries, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/alextanhongpin/redis-learn/timeseries/go/timeseries"
+	"github.com/go-redis/redis"
+)
+
+func NewClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+}
+
+// Usage:
+//
+//	go run . -backend hash
+func main() {
+	backend := flag.String("backend", "string", "one of "+strings.Join(timeseries.Backends, ", "))
+	flag.Parse()
+
+	client := NewClient()
+	// NOTE: Using / will cause an error here.
+	store, err := timeseries.NewStore(*backend, client, "go.srv:timeseries", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var startTimestamp int64
+	log.Println(store.Insert(startTimestamp, "user:max"))
+	store.Insert(startTimestamp, "user:max")
+	store.Insert(startTimestamp+1, "user:hugo")
+	store.Insert(startTimestamp+1, "user:renata")
+	store.Insert(startTimestamp+3, "user:hugo")
+	store.Insert(startTimestamp+61, "user:kc")
+	{
+		results, err := store.Fetch("1sec", startTimestamp, startTimestamp+3)
+		if err != nil {
+			log.Fatal(err)
+		}
+		displayResults("1sec", results)
+
+	}
+	{
+		results, err := store.Fetch("1min", startTimestamp, startTimestamp+120)
+		if err != nil {
+			log.Fatal(err)
+		}
+		displayResults("1min", results)
+	}
+
+	// A thousand samples are written in a single transaction.
+	samples := make([]timeseries.Sample, 1000)
+	for i := range samples {
+		samples[i] = timeseries.Sample{Timestamp: startTimestamp + timeseries.Hour + int64(i), ID: fmt.Sprintf("user:%d", i%100)}
+	}
+	if err := store.InsertBatch(samples); err != nil {
+		log.Fatal(err)
+	}
+	{
+		results, err := store.Fetch("1hour", startTimestamp, startTimestamp+timeseries.Hour)
+		if err != nil {
+			log.Fatal(err)
+		}
+		displayResults("1hour", results)
+	}
+}
+
+func displayResults(granularityName string, results []timeseries.Series) {
+	fmt.Println("result from ", granularityName)
+	for _, result := range results {
+		fmt.Println(result.Timestamp, result.Value)
+	}
+	fmt.Println()
+}
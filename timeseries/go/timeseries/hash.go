@@ -0,0 +1,87 @@
+package timeseries
+
+import (
+	"strconv"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// These values are based on the hash-max-ziplist-entries of 512 so that data
+// will be stored in the memory-optimized ziplist.
+var hashGranularities = map[string]Granularity{
+	// Stores 300 timestamps of 1 second each.
+	"1sec": {"1sec", 2 * Hour, Second, 5 * Minute},
+	// Stores 480 timestamps of 1 minute each.
+	"1min": {"1min", 7 * Day, Minute, 8 * Hour},
+	// Stores 240 timestamps of 1 hour each.
+	"1hour": {"1hour", 60 * Day, Hour, 10 * Day},
+	// Stores a maximum of 30 timestamps of 1 day each.
+	"1day": {"1day", -1, Day, 30 * Day},
+}
+
+// HashStore counts events in a hash field per time-window, with Quantity
+// seconds of time-windows per hash. See README.md.
+type HashStore struct {
+	timeSeries
+}
+
+func NewHashStore(client *redis.Client, namespace string, granularities map[string]Granularity) *HashStore {
+	return &HashStore{newTimeSeries(client, namespace, granularities, hashGranularities)}
+}
+
+func (t *HashStore) Insert(timestampInSeconds int64, id string) error {
+	return t.InsertBatch([]Sample{{timestampInSeconds, id}})
+}
+
+func (t *HashStore) InsertBatch(samples []Sample) error {
+	return t.insert(samples, func(pipe redis.Pipeliner, granularity Granularity, key string, samples []Sample) {
+		var fields []int64
+		counts := make(map[int64]int64)
+		for _, s := range samples {
+			field := roundTimestamp(s.Timestamp, granularity.Duration)
+			if _, ok := counts[field]; !ok {
+				fields = append(fields, field)
+			}
+			counts[field]++
+		}
+		for _, field := range fields {
+			pipe.HIncrBy(key, strconv.FormatInt(field, 10), counts[field])
+		}
+	})
+}
+
+func (t *HashStore) Fetch(granularityName string, startTimestamp, endTimestamp int64) ([]Series, error) {
+	granularity, err := t.granularity(granularityName)
+	if err != nil {
+		return nil, err
+	}
+	tss := timestamps(granularity, startTimestamp, endTimestamp)
+
+	var result []*redis.StringCmd
+	_, err = t.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, ts := range tss {
+			key := t.key(granularity, ts)
+			field := strconv.FormatInt(ts, 10)
+			result = append(result, pipe.HGet(key, field))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "pipeline failed")
+	}
+	output := make([]Series, len(result))
+	for i, res := range result {
+		val := res.Val()
+		var count int64
+		if val != "" {
+			var err error
+			count, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		output[i] = Series{Timestamp: tss[i], Value: count}
+	}
+	return output, nil
+}
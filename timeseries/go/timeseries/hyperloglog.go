@@ -0,0 +1,54 @@
+package timeseries
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// HyperLogLogStore counts unique ids per time-window, approximately, in a
+// HyperLogLog per time-window.
+type HyperLogLogStore struct {
+	timeSeries
+}
+
+func NewHyperLogLogStore(client *redis.Client, namespace string, granularities map[string]Granularity) *HyperLogLogStore {
+	return &HyperLogLogStore{newTimeSeries(client, namespace, granularities, defaultGranularities)}
+}
+
+func (t *HyperLogLogStore) Insert(timestampInSeconds int64, id string) error {
+	return t.InsertBatch([]Sample{{timestampInSeconds, id}})
+}
+
+func (t *HyperLogLogStore) InsertBatch(samples []Sample) error {
+	return t.insert(samples, func(pipe redis.Pipeliner, granularity Granularity, key string, samples []Sample) {
+		ids := make([]interface{}, len(samples))
+		for i, s := range samples {
+			ids[i] = s.ID
+		}
+		pipe.PFAdd(key, ids...)
+	})
+}
+
+func (t *HyperLogLogStore) Fetch(granularityName string, startTimestamp, endTimestamp int64) ([]Series, error) {
+	granularity, err := t.granularity(granularityName)
+	if err != nil {
+		return nil, err
+	}
+	tss := timestamps(granularity, startTimestamp, endTimestamp)
+
+	var result []*redis.IntCmd
+	_, err = t.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, ts := range tss {
+			result = append(result, pipe.PFCount(t.key(granularity, ts)))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "pipeline failed")
+	}
+	output := make([]Series, len(result))
+	for i, res := range result {
+		output[i] = Series{Timestamp: tss[i], Value: res.Val()}
+	}
+	return output, nil
+}
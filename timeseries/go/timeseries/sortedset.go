@@ -0,0 +1,76 @@
+package timeseries
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// This is based on the Sorted Set configuration zset-max-ziplist-entries,
+// which defaults to 128.
+var sortedSetGranularities = map[string]Granularity{
+	// Stores a maximum of 120 timestamps of 1 second each.
+	"1sec": {"1sec", 2 * Hour, Second, 2 * Minute},
+	// Stores a maximum of 120 timestamps of 1 minute each.
+	"1min": {"1min", 7 * Day, Minute, 2 * Hour},
+	// Stores a maximum of 120 timestamps of 1 hour each.
+	"1hour": {"1hour", 60 * Day, Hour, 5 * Day},
+	// Stores a maximum of 30 timestamps of 1 day each.
+	"1day": {"1day", -1, Day, 30 * Day},
+}
+
+// SortedSetStore counts unique ids per time-window exactly, as members of a
+// sorted set scored by their time-window, with Quantity seconds of
+// time-windows per sorted set.
+type SortedSetStore struct {
+	timeSeries
+}
+
+func NewSortedSetStore(client *redis.Client, namespace string, granularities map[string]Granularity) *SortedSetStore {
+	return &SortedSetStore{newTimeSeries(client, namespace, granularities, sortedSetGranularities)}
+}
+
+func (t *SortedSetStore) Insert(timestampInSeconds int64, id string) error {
+	return t.InsertBatch([]Sample{{timestampInSeconds, id}})
+}
+
+func (t *SortedSetStore) InsertBatch(samples []Sample) error {
+	return t.insert(samples, func(pipe redis.Pipeliner, granularity Granularity, key string, samples []Sample) {
+		members := make([]redis.Z, len(samples))
+		for i, s := range samples {
+			score := roundTimestamp(s.Timestamp, granularity.Duration)
+			members[i] = redis.Z{
+				Score:  float64(score),
+				Member: fmt.Sprintf("%d:%s", score, s.ID),
+			}
+		}
+		pipe.ZAdd(key, members...)
+	})
+}
+
+func (t *SortedSetStore) Fetch(granularityName string, startTimestamp, endTimestamp int64) ([]Series, error) {
+	granularity, err := t.granularity(granularityName)
+	if err != nil {
+		return nil, err
+	}
+	tss := timestamps(granularity, startTimestamp, endTimestamp)
+
+	var result []*redis.IntCmd
+	_, err = t.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, ts := range tss {
+			timestamp := strconv.FormatInt(ts, 10)
+			result = append(result, pipe.ZCount(t.key(granularity, ts), timestamp, timestamp))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "pipeline failed")
+	}
+	output := make([]Series, len(result))
+	for i, res := range result {
+		output[i] = Series{Timestamp: tss[i], Value: res.Val()}
+	}
+	return output, nil
+}
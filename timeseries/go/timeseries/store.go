@@ -0,0 +1,180 @@
+// Package timeseries counts events over time at several granularities, with
+// interchangeable Redis backends behind Store.
+package timeseries
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	Second = 1
+	Minute = 60
+	Hour   = 60 * 60
+	Day    = 24 * 60 * 60
+)
+
+var ErrUnknownGranularity = errors.New("granularity does not exist")
+
+// insertBatchSize is the number of samples written per transaction by
+// InsertBatch.
+const insertBatchSize = 1000
+
+// Store records events and counts them per granularity. id identifies who or
+// what caused the event. Backends counting unique events count each id once
+// per time window, the others ignore it.
+//
+// Inserts update every granularity and set the TTLs in a single transaction,
+// so either all of it is applied or none of it.
+type Store interface {
+	Insert(timestampInSeconds int64, id string) error
+	InsertBatch(samples []Sample) error
+	Fetch(granularityName string, startTimestamp, endTimestamp int64) ([]Series, error)
+}
+
+// Sample is an event to insert.
+type Sample struct {
+	Timestamp int64
+	ID        string
+}
+
+type Series struct {
+	Timestamp, Value int64
+}
+
+type Granularity struct {
+	Name     string // The level of the granularity.
+	TTL      int64  // The duration to keep the data, or -1 to keep it forever.
+	Duration int64  // The time-window to store the data.
+
+	// Quantity is the time span of each key, for backends storing several
+	// time-windows per key. It defaults to Duration.
+	Quantity int64
+}
+
+func (g Granularity) span() int64 {
+	if g.Quantity > 0 {
+		return g.Quantity
+	}
+	return g.Duration
+}
+
+// One key per time-window.
+var defaultGranularities = map[string]Granularity{
+	"1sec":  {"1sec", 2 * Hour, Second, 0},
+	"1min":  {"1min", 7 * Day, Minute, 0},
+	"1hour": {"1hour", 60 * Day, Hour, 0},
+	"1day":  {"1day", -1, Day, 0},
+}
+
+// Backends are the backends NewStore accepts.
+var Backends = []string{"string", "hash", "hyperloglog", "sorted-set"}
+
+// NewStore creates the store of the given backend, one of Backends, so that
+// the backend can be picked by configuration. A nil granularities uses the defaults of the backend.
+func NewStore(backend string, client *redis.Client, namespace string, granularities map[string]Granularity) (Store, error) {
+	switch backend {
+	case "string":
+		return NewStringStore(client, namespace, granularities), nil
+	case "hash":
+		return NewHashStore(client, namespace, granularities), nil
+	case "hyperloglog":
+		return NewHyperLogLogStore(client, namespace, granularities), nil
+	case "sorted-set":
+		return NewSortedSetStore(client, namespace, granularities), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// timeSeries is the part shared by all the backends.
+type timeSeries struct {
+	client        *redis.Client
+	namespace     string
+	granularities map[string]Granularity
+}
+
+func newTimeSeries(client *redis.Client, namespace string, granularities, defaults map[string]Granularity) timeSeries {
+	if len(granularities) == 0 {
+		granularities = defaults
+	}
+	return timeSeries{
+		client:        client,
+		namespace:     namespace,
+		granularities: granularities,
+	}
+}
+
+func (t *timeSeries) granularity(name string) (Granularity, error) {
+	granularity, ok := t.granularities[name]
+	if !ok {
+		return Granularity{}, ErrUnknownGranularity
+	}
+	return granularity, nil
+}
+
+// addFunc queues the commands adding samples, which all belong to key, on
+// pipe.
+type addFunc func(pipe redis.Pipeliner, granularity Granularity, key string, samples []Sample)
+
+// insert writes samples in transactions of up to insertBatchSize samples,
+// each sent in a single round trip. Samples are grouped by key, so that add
+// is called and the TTL set once per key and transaction.
+func (t *timeSeries) insert(samples []Sample, add addFunc) error {
+	for len(samples) > 0 {
+		batch := samples
+		if len(batch) > insertBatchSize {
+			batch = batch[:insertBatchSize]
+		}
+		samples = samples[len(batch):]
+
+		_, err := t.client.TxPipelined(func(pipe redis.Pipeliner) error {
+			for _, granularity := range t.granularities {
+				var keys []string
+				byKey := make(map[string][]Sample)
+				for _, s := range batch {
+					key := t.key(granularity, s.Timestamp)
+					if _, ok := byKey[key]; !ok {
+						keys = append(keys, key)
+					}
+					byKey[key] = append(byKey[key], s)
+				}
+				for _, key := range keys {
+					add(pipe, granularity, key, byKey[key])
+					if granularity.TTL > 0 {
+						pipe.Expire(key, time.Duration(granularity.TTL)*time.Second)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *timeSeries) key(granularity Granularity, timestampInSeconds int64) string {
+	roundedTimestamp := roundTimestamp(timestampInSeconds, granularity.span())
+	return fmt.Sprintf("%s:%s:%d", t.namespace, granularity.Name, roundedTimestamp)
+}
+
+func roundTimestamp(timestampInSeconds, precision int64) int64 {
+	return timestampInSeconds - (timestampInSeconds % precision)
+}
+
+// timestamps returns the start of every time-window of granularity between
+// startTimestamp and endTimestamp.
+func timestamps(granularity Granularity, startTimestamp, endTimestamp int64) []int64 {
+	start := roundTimestamp(startTimestamp, granularity.Duration)
+	end := roundTimestamp(endTimestamp, granularity.Duration)
+	var result []int64
+	for ts := start; ts <= end; ts += granularity.Duration {
+		result = append(result, ts)
+	}
+	return result
+}
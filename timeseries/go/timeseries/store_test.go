@@ -0,0 +1,128 @@
+package timeseries
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// newTestClient connects to the Redis at REDIS_ADDR, localhost:6379 by
+// default, and skips the test if there is none.
+func newTestClient(t *testing.T) *redis.Client {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		t.Skipf("redis not available at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newTestStore creates a store of backend in a namespace of its own, whose
+// keys are deleted after the test.
+func newTestStore(t *testing.T, client *redis.Client, backend string) Store {
+	namespace := fmt.Sprintf("test:timeseries:%s:%d", backend, time.Now().UnixNano())
+	t.Cleanup(func() {
+		iter := client.Scan(0, namespace+":*", 100).Iterator()
+		for iter.Next() {
+			client.Del(iter.Val())
+		}
+	})
+	store, err := NewStore(backend, client, namespace, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// TestStore checks that every backend behaves the same. Each id is inserted
+// once, so that backends counting all events and backends counting unique
+// ids must agree.
+func TestStore(t *testing.T) {
+	client := newTestClient(t)
+	for _, backend := range Backends {
+		t.Run(backend, func(t *testing.T) {
+			store := newTestStore(t, client, backend)
+			if err := store.Insert(0, "user:max"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.InsertBatch([]Sample{
+				{1, "user:hugo"},
+				{1, "user:renata"},
+				{3, "user:kc"},
+				{61, "user:john"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				granularity string
+				start, end  int64
+				want        []Series
+			}{
+				{"1sec", 0, 3, []Series{{0, 1}, {1, 2}, {2, 0}, {3, 1}}},
+				// Timestamps are rounded down to the start of their
+				// time-window.
+				{"1sec", 1, 1, []Series{{1, 2}}},
+				{"1min", 30, 150, []Series{{0, 4}, {60, 1}, {120, 0}}},
+				{"1hour", 0, 0, []Series{{0, 5}}},
+				{"1day", 0, Day, []Series{{0, 5}, {Day, 0}}},
+			}
+			for _, tt := range tests {
+				got, err := store.Fetch(tt.granularity, tt.start, tt.end)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Fetch(%q, %d, %d) = %v, want %v", tt.granularity, tt.start, tt.end, got, tt.want)
+				}
+			}
+
+			if _, err := store.Fetch("1year", 0, 0); err != ErrUnknownGranularity {
+				t.Errorf("Fetch(%q) error = %v, want %v", "1year", err, ErrUnknownGranularity)
+			}
+		})
+	}
+}
+
+// TestStoreInsertBatch checks batches spanning several transactions.
+func TestStoreInsertBatch(t *testing.T) {
+	client := newTestClient(t)
+	tests := []struct {
+		backend string
+		want    int64
+	}{
+		{"string", 2500},
+		{"hash", 2500},
+		// The same id is counted once.
+		{"hyperloglog", 1},
+		{"sorted-set", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			store := newTestStore(t, client, tt.backend)
+			samples := make([]Sample, 2500)
+			for i := range samples {
+				samples[i] = Sample{Timestamp: int64(i % Hour), ID: "user:max"}
+			}
+			if err := store.InsertBatch(samples); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.Fetch("1hour", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []Series{{0, tt.want}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Fetch(%q, 0, 0) = %v, want %v", "1hour", got, want)
+			}
+		})
+	}
+}
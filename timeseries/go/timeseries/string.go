@@ -0,0 +1,60 @@
+package timeseries
+
+import (
+	"strconv"
+
+	"github.com/go-redis/redis"
+)
+
+// StringStore counts events in a counter per time-window.
+type StringStore struct {
+	timeSeries
+}
+
+func NewStringStore(client *redis.Client, namespace string, granularities map[string]Granularity) *StringStore {
+	return &StringStore{newTimeSeries(client, namespace, granularities, defaultGranularities)}
+}
+
+func (t *StringStore) Insert(timestampInSeconds int64, id string) error {
+	return t.InsertBatch([]Sample{{timestampInSeconds, id}})
+}
+
+func (t *StringStore) InsertBatch(samples []Sample) error {
+	return t.insert(samples, func(pipe redis.Pipeliner, granularity Granularity, key string, samples []Sample) {
+		pipe.IncrBy(key, int64(len(samples)))
+	})
+}
+
+func (t *StringStore) Fetch(granularityName string, startTimestamp, endTimestamp int64) ([]Series, error) {
+	granularity, err := t.granularity(granularityName)
+	if err != nil {
+		return nil, err
+	}
+	tss := timestamps(granularity, startTimestamp, endTimestamp)
+	keys := make([]string, len(tss))
+	for i, ts := range tss {
+		keys[i] = t.key(granularity, ts)
+	}
+	res, err := t.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Series, len(res))
+	for i := 0; i < len(res); i++ {
+		var val int64
+		if res[i] != nil {
+			// Convert from interface to redis string.
+			s, _ := res[i].(string)
+
+			// Parse the string value into int64.
+			var err error
+			val, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result[i] = Series{Timestamp: tss[i], Value: val}
+	}
+	return result, nil
+}